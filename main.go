@@ -4,10 +4,16 @@ import (
 	"flag"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	log "github.com/schollz/logger"
+	"github.com/schollz/streammyaudio/src/chat"
 	"github.com/schollz/streammyaudio/src/client"
+	"github.com/schollz/streammyaudio/src/irc"
 	"github.com/schollz/streammyaudio/src/server"
+	"github.com/schollz/streammyaudio/src/storage"
+	"github.com/schollz/streammyaudio/src/webhook"
 )
 
 var streamName, streamAdvertise, streamArchive, streamServer string
@@ -16,6 +22,36 @@ var flagPort int
 var flagFolder string
 var flagServer bool
 var flagQuality int
+var flagDevice, flagCodec, flagProfile, flagSaveProfile, flagCastConfig string
+var flagListDevices bool
+var flagSource string
+var flagShuffle, flagLoop bool
+var flagRecord, flagLossless string
+var flagTargets []client.Target
+var flagGain float64
+var flagPreset string
+var flagHighpass, flagGate, flagCompress, flagLoudness, flagLimit float64
+var flagMix []client.Input
+var flagUpload string
+var flagMaxUpload int64
+var flagAdminToken string
+var flagConflict string
+var flagChatHistory int
+var flagChatHistoryAge time.Duration
+var flagChatFolder string
+var flagChatRate int
+var flagChatFree bool
+var flagChatRooms string
+var flagChatGrace time.Duration
+var flagResumeGrace time.Duration
+var flagTrimSilence time.Duration
+var flagSilence time.Duration
+var flagSilenceAction string
+var flagIRCServer, flagIRCNick, flagIRCRooms string
+var flagIRCTLS bool
+var flagWebhooks, flagWebhookSecret string
+var flagWebhookChat bool
+var flagS3Endpoint, flagS3Region, flagS3Bucket, flagS3Prefix string
 
 // init initializes the clearScreen variable for MacOS, Linux, & Windows
 func init() {
@@ -28,6 +64,60 @@ func init() {
 	flag.StringVar(&streamArchive, "cast-archive", "", "cast stream archive (yes/no)")
 	flag.StringVar(&streamServer, "cast-server", "https://streammyaudio.com", "cast server address")
 	flag.IntVar(&flagQuality, "cast-quality", -1, "cast audio quality (0 = best to 9 = worst)")
+	flag.StringVar(&flagDevice, "cast-device", "", "cast from this audio device, by name or index")
+	flag.Float64Var(&flagGain, "cast-gain", 0, "gain of the device or --cast-source in dB")
+	flag.Func("cast-mix", "also mix in this audio device, by name or index, with a gain in dB after @ (e.g. \"Monitor of Speakers@-6\", can be repeated)", func(s string) error {
+		in, err := client.ParseInput(s)
+		flagMix = append(flagMix, in)
+		return err
+	})
+	flag.StringVar(&flagSource, "cast-source", "", "cast a file, .m3u playlist or URL instead of an audio device")
+	flag.BoolVar(&flagShuffle, "cast-shuffle", false, "play the tracks of --cast-source in random order")
+	flag.BoolVar(&flagLoop, "cast-loop", false, "start --cast-source over when it ends")
+	flag.StringVar(&flagRecord, "cast-record", "", "also save the stream to this file, {name} and {time} are filled in (e.g. {name}-{time}.mp3)")
+	flag.StringVar(&flagLossless, "cast-record-lossless", "", "also save a lossless .wav or .flac copy of the audio to this file, {name} and {time} are filled in")
+	flag.Func("cast-target", "also cast to this server, as server[,name=...][,advertise=yes/no][,archive=yes/no] (can be repeated)", func(s string) error {
+		t, err := client.ParseTarget(s)
+		flagTargets = append(flagTargets, t)
+		return err
+	})
+	flag.BoolVar(&flagListDevices, "cast-devices", false, "list the audio devices and their index")
+	flag.StringVar(&flagCodec, "cast-codec", client.CodecMP3, "cast codec: mp3 (variable bitrate) or mp3-cbr (constant bitrate)")
+	flag.StringVar(&flagPreset, "cast-preset", client.PresetRaw, "cast processing: raw (none), voice or music")
+	flag.Float64Var(&flagLoudness, "cast-loudness", 0, "loudness in LUFS to normalize to, instead of the preset's (e.g. -16, 0 does not)")
+	flag.Float64Var(&flagHighpass, "cast-highpass", 0, "cut rumble below this frequency in Hz, instead of the preset's (0 does not)")
+	flag.Float64Var(&flagGate, "cast-gate", 0, "mute whatever is quieter than this in dB, instead of the preset's (e.g. -50, 0 does not)")
+	flag.Float64Var(&flagCompress, "cast-compress", 0, "compress loud parts with this ratio, instead of the preset's (e.g. 4, 0 does not)")
+	flag.Float64Var(&flagLimit, "cast-limit", 0, "keep peaks under this in dB, instead of the preset's (e.g. -1, 0 does not)")
+	flag.DurationVar(&flagSilence, "cast-silence", 0, "act when the input is silent for this long (e.g. 5m, 0 never does)")
+	flag.StringVar(&flagSilenceAction, "cast-silence-action", client.SilenceWarn, "what to do about silence: warn, chat (also tell the chat) or stop (also end the stream)")
+	flag.StringVar(&flagProfile, "cast-profile", "", "cast with the choices saved in this profile")
+	flag.StringVar(&flagSaveProfile, "cast-save-profile", "", "save the choices as a profile with this name")
+	flag.StringVar(&flagCastConfig, "cast-config", "", "profiles file (default streammyaudio/profiles.json in the user config folder)")
+	flag.StringVar(&flagUpload, "upload", "", "upload a pre-recorded file to the archive")
+	flag.Int64Var(&flagMaxUpload, "server-max-upload", 200, "largest upload in MB")
+	flag.StringVar(&flagAdminToken, "server-admin-token", "", "token that may edit any archive")
+	flag.DurationVar(&flagResumeGrace, "server-resume-grace", server.DefaultResumeGrace, "how long a broadcaster whose connection dropped may resume into the same archive")
+	flag.DurationVar(&flagTrimSilence, "server-trim-silence", 0, "cut silences longer than this out of archives when their stream ends (e.g. 2m, 0 keeps them)")
+	flag.StringVar(&flagConflict, "server-conflict", server.ConflictReject, "when a second broadcaster uses a live stream name: reject, takeover or suffix")
+	flag.IntVar(&flagChatHistory, "server-chat-history", chat.DefaultHistorySize, "chat messages replayed to people who join late")
+	flag.DurationVar(&flagChatHistoryAge, "server-chat-history-age", chat.DefaultHistoryAge, "how long chat messages are replayed")
+	flag.StringVar(&flagChatFolder, "server-chat-folder", "", "folder to save chat history in, so it survives a restart")
+	flag.IntVar(&flagChatRate, "server-chat-rate", chat.DefaultRateLimit, "chat messages each listener may send per 10 seconds (0 = unlimited)")
+	flag.BoolVar(&flagChatFree, "server-chat-free", false, "allow chat rooms that do not belong to a stream")
+	flag.StringVar(&flagChatRooms, "server-chat-rooms", "", "comma-separated chat rooms that are always open")
+	flag.DurationVar(&flagChatGrace, "server-chat-grace", server.DefaultChatGrace, "how long a stream's chat stays open after the stream ends")
+	flag.StringVar(&flagIRCServer, "server-irc", "", "relay chat rooms to this IRC server (host:port)")
+	flag.BoolVar(&flagIRCTLS, "server-irc-tls", false, "connect to the IRC server with TLS")
+	flag.StringVar(&flagIRCNick, "server-irc-nick", "streammyaudio", "nickname of the IRC bridge")
+	flag.StringVar(&flagIRCRooms, "server-irc-rooms", "", "comma-separated chat rooms to relay, as room or room=#channel (#room by default)")
+	flag.StringVar(&flagWebhooks, "server-webhook", "", "comma-separated URLs to POST stream and archive events to")
+	flag.StringVar(&flagWebhookSecret, "server-webhook-secret", "", "secret that signs webhook bodies (X-Signature-256)")
+	flag.BoolVar(&flagWebhookChat, "server-webhook-chat", false, "also POST every chat message to the webhooks")
+	flag.StringVar(&flagS3Endpoint, "server-s3-endpoint", "", "keep archives in S3-compatible storage at this endpoint (credentials from AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	flag.StringVar(&flagS3Region, "server-s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&flagS3Bucket, "server-s3-bucket", "", "S3 bucket for archives")
+	flag.StringVar(&flagS3Prefix, "server-s3-prefix", "", "S3 key prefix for archives")
 }
 
 func main() {
@@ -45,22 +135,167 @@ func main() {
 	var err error
 	if flagServer {
 		os.MkdirAll(flagFolder, os.ModePerm)
+		hub := chat.NewHub()
+		hub.HistorySize = flagChatHistory
+		hub.HistoryAge = flagChatHistoryAge
+		hub.HistoryFolder = flagChatFolder
+		hub.RateLimit = flagChatRate
 		s := &server.Server{
-			Port:   flagPort,
-			Folder: flagFolder,
+			Port:          flagPort,
+			Folder:        flagFolder,
+			MaxUpload:     flagMaxUpload << 20,
+			AdminToken:    flagAdminToken,
+			Conflict:      flagConflict,
+			ResumeGrace:   flagResumeGrace,
+			TrimSilence:   flagTrimSilence,
+			FreeChatRooms: flagChatFree,
+			ChatGrace:     flagChatGrace,
+			Chat:          hub,
+		}
+		for _, room := range strings.Split(flagChatRooms, ",") {
+			if room = strings.TrimSpace(room); room != "" {
+				s.ChatRooms = append(s.ChatRooms, room)
+			}
+		}
+		for _, u := range strings.Split(flagWebhooks, ",") {
+			if u = strings.TrimSpace(u); u == "" {
+				continue
+			}
+			if s.Webhooks == nil {
+				s.Webhooks = &webhook.Hooks{Secret: flagWebhookSecret}
+			}
+			s.Webhooks.URLs = append(s.Webhooks.URLs, u)
+		}
+		s.WebhookChat = flagWebhookChat
+		if flagIRCServer != "" {
+			s.IRC = &irc.Bridge{
+				Server:   flagIRCServer,
+				TLS:      flagIRCTLS,
+				Nick:     flagIRCNick,
+				Channels: make(map[string]string),
+			}
+			for _, room := range strings.Split(flagIRCRooms, ",") {
+				room, channel, ok := strings.Cut(strings.TrimSpace(room), "=")
+				if room == "" {
+					continue
+				}
+				if !ok {
+					channel = "#" + room
+				}
+				s.IRC.Channels[room] = channel
+			}
+		}
+		if flagS3Endpoint != "" {
+			s.Storage = &storage.S3{
+				Endpoint:  flagS3Endpoint,
+				Region:    flagS3Region,
+				Bucket:    flagS3Bucket,
+				Prefix:    flagS3Prefix,
+				AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			}
 		}
 		err = s.Run()
+	} else if flagUpload != "" {
+		c := &client.Client{
+			Name:   streamName,
+			Server: streamServer,
+		}
+		err = c.Upload(flagUpload)
+	} else if flagListDevices {
+		err = (&client.Client{}).ListDevices()
 	} else {
 		c := &client.Client{
-			Name:      streamName,
-			Archive:   streamArchive,
-			Advertise: streamAdvertise,
-			Server:    streamServer,
-			Quality: flagQuality,
+			Name:          streamName,
+			Archive:       streamArchive,
+			Advertise:     streamAdvertise,
+			Server:        streamServer,
+			Quality:       flagQuality,
+			Device:        flagDevice,
+			Gain:          flagGain,
+			Mix:           flagMix,
+			Codec:         flagCodec,
+			Preset:        flagPreset,
+			Config:        flagCastConfig,
+			SaveProfile:   flagSaveProfile,
+			Source:        flagSource,
+			Shuffle:       flagShuffle,
+			Loop:          flagLoop,
+			Record:        flagRecord,
+			Lossless:      flagLossless,
+			Targets:       flagTargets,
+			Silence:       flagSilence,
+			SilenceAction: flagSilenceAction,
+		}
+		if flagProfile != "" {
+			var p client.Profile
+			p, err = client.LoadProfile(flagCastConfig, flagProfile)
+			if err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+			c.Apply(p)
+			// flags given on the command line win over the profile
+			flag.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "cast-name":
+					c.Name = streamName
+				case "cast-advertise":
+					c.Advertise = streamAdvertise
+				case "cast-archive":
+					c.Archive = streamArchive
+				case "cast-server":
+					c.Server = streamServer
+				case "cast-quality":
+					c.Quality = flagQuality
+				case "cast-device":
+					c.Device = flagDevice
+				case "cast-gain":
+					c.Gain = flagGain
+				case "cast-mix":
+					c.Mix = flagMix
+				case "cast-codec":
+					c.Codec = flagCodec
+				case "cast-preset":
+					c.Preset = flagPreset
+				case "cast-source":
+					c.Source = flagSource
+				case "cast-shuffle":
+					c.Shuffle = flagShuffle
+				case "cast-loop":
+					c.Loop = flagLoop
+				case "cast-record":
+					c.Record = flagRecord
+				case "cast-record-lossless":
+					c.Lossless = flagLossless
+				case "cast-target":
+					c.Targets = flagTargets
+				case "cast-silence":
+					c.Silence = flagSilence
+				case "cast-silence-action":
+					c.SilenceAction = flagSilenceAction
+				}
+			})
 		}
+		// the preset decides the steps that are not given
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "cast-highpass":
+				c.Highpass = &flagHighpass
+			case "cast-gate":
+				c.Gate = &flagGate
+			case "cast-compress":
+				c.Compress = &flagCompress
+			case "cast-loudness":
+				c.Loudness = &flagLoudness
+			case "cast-limit":
+				c.Limit = &flagLimit
+			}
+		})
 		err = c.Run()
 	}
 	if err != nil {
 		log.Debugf("err: %+v", err)
+		os.Exit(1)
 	}
 }
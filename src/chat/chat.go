@@ -1,9 +1,17 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	log "github.com/schollz/logger"
@@ -20,9 +28,27 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	maxMessageSize = 4096
+
+	// Longest room name.
+	maxRoomLength = 100
+
+	// Messages waiting to be written to a connection before it is dropped
+	// as too slow.
+	sendBufferSize = 256
+)
+
+// Defaults of a new Hub
+const (
+	DefaultHistorySize = 100
+	DefaultHistoryAge  = 2 * time.Hour
+	DefaultRateLimit   = 5
+	DefaultRatePeriod  = 10 * time.Second
 )
 
+// Time between saves of room histories to HistoryFolder
+const historySavePeriod = 10 * time.Second
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -30,18 +56,42 @@ var upgrader = websocket.Upgrader{
 
 // connection is an middleman between the websocket connection and the hub.
 type connection struct {
+	// The hub the connection belongs to.
+	hub *Hub
+
 	// The websocket connection.
 	ws *websocket.Conn
 
-	// Buffered channel of outbound messages.
+	// Buffered channel of outbound messages. Only the hub closes it, when
+	// it drops the connection.
 	send chan []byte
+
+	// The address the connection came from.
+	ip string
+
+	// Where a relay connection brings messages from, empty for people.
+	via string
+
+	// The fields below are only used by the hub.
+
+	// Whether the connection authenticated with the room's stream key.
+	host bool
+
+	// The name last used to chat, or given when connecting.
+	name string
+
+	// Rate limiting and slow mode.
+	tokens     float64
+	lastRefill time.Time
+	lastSent   time.Time
 }
 
 // readPump pumps messages from the websocket connection to the hub.
 func (s subscription) readPump() {
 	c := s.conn
+	h := c.hub
 	defer func() {
-		h.unregister <- s
+		submit(h, h.unregister, s)
 		c.ws.Close()
 	}()
 	c.ws.SetReadLimit(maxMessageSize)
@@ -55,8 +105,17 @@ func (s subscription) readPump() {
 			}
 			break
 		}
-		m := message{msg, s.room}
-		h.broadcast <- m
+		m, err := parseMessage(msg)
+		if err != nil {
+			log.Debugf("rejected message in '%s': %s", s.room, err)
+			if !submit(h, h.reply, reply{s, Message{Type: TypeError, Text: err.Error()}}) {
+				break
+			}
+			continue
+		}
+		if !submit(h, h.broadcast, message{m, s}) {
+			break
+		}
 	}
 }
 
@@ -92,23 +151,60 @@ func (s *subscription) writePump() {
 	}
 }
 
-// serveWs handles websocket requests from the peer.
-func Serve(w http.ResponseWriter, r *http.Request) {
-	ws, err := upgrader.Upgrade(w, r, nil)
-	vals := r.URL.Query()    // Returns a url.Values, which is a map[string][]string
-	room, ok := vals["room"] // Note type, not ID. ID wasn't specified anywhere.
-	if !ok {
-		err = fmt.Errorf("no room specified")
+// ServeHTTP joins the room given in the "room" query parameter over a
+// websocket. A "name" parameter sets the nickname, and a "key" parameter
+// authenticates the host right away.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vals := r.URL.Query()
+	room := vals.Get("room")
+	if room == "" || utf8.RuneCountInString(room) > maxRoomLength || room != sanitize(room) {
+		http.Error(w, "no room specified", http.StatusBadRequest)
+		return
+	}
+	if h.AllowRoom != nil && !h.AllowRoom(room) {
+		http.Error(w, fmt.Sprintf("'%s' is not a stream", room), http.StatusNotFound)
+		return
+	}
+	select {
+	case <-h.done:
+		http.Error(w, "chat is shut down", http.StatusServiceUnavailable)
 		return
+	default:
 	}
-	log.Debugf("entered '%s'", room[0])
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error(err)
 		return
 	}
-	c := &connection{send: make(chan []byte, 256), ws: ws}
-	s := subscription{c, room[0]}
-	h.register <- s
+	log.Debugf("entered '%s'", room)
+	ip, _, errSplit := net.SplitHostPort(r.RemoteAddr)
+	if errSplit != nil {
+		ip = r.RemoteAddr
+	}
+	name := sanitize(vals.Get("name"))
+	if utf8.RuneCountInString(name) > maxNameLength {
+		name = ""
+	}
+	c := &connection{
+		hub:        h,
+		send:       make(chan []byte, sendBufferSize),
+		ws:         ws,
+		ip:         ip,
+		name:       name,
+		tokens:     float64(h.RateLimit),
+		lastRefill: time.Now(),
+	}
+	s := subscription{c, room}
+	if !submit(h, h.register, s) {
+		ws.Close()
+		return
+	}
+	if key := vals.Get("key"); key != "" {
+		// the host can authenticate right away instead of with a message,
+		// and then takes the name even if it is reserved by another of the
+		// host's connections
+		submit(h, h.broadcast, message{Message{Type: TypeAuth, Key: key, Name: name}, s})
+	}
 	go s.writePump()
 	s.readPump()
 }
@@ -117,8 +213,20 @@ func Serve(w http.ResponseWriter, r *http.Request) {
 // hub
 ///////////////////////
 type message struct {
-	data []byte
+	msg Message
+	sub subscription
+}
+
+// reply is a message for a single subscription
+type reply struct {
+	sub subscription
+	msg Message
+}
+
+// notice is a system message for a room from outside of the chat
+type notice struct {
 	room string
+	text string
 }
 
 type subscription struct {
@@ -126,9 +234,45 @@ type subscription struct {
 	room string
 }
 
-// hub maintains the set of active connections and broadcasts messages to the
-// connections.
-type hub struct {
+// Hub maintains the set of active connections and broadcasts messages to the
+// connections. Its fields configure it and must be set before Run.
+type Hub struct {
+	// HistorySize is how many messages each room keeps to replay to people
+	// who join late
+	HistorySize int
+
+	// HistoryAge is how long a message stays in a room's history
+	HistoryAge time.Duration
+
+	// HistoryFolder is where room histories are saved so they survive a
+	// restart. Histories are only kept in memory if it is empty.
+	HistoryFolder string
+
+	// RateLimit is how many messages a connection may send in a burst. After
+	// that it may send RateLimit messages every RatePeriod. Zero turns rate
+	// limiting off.
+	RateLimit  int
+	RatePeriod time.Duration
+
+	// Authenticate reports whether key is the stream key of room, which
+	// makes whoever holds it the host of the room. Nobody can moderate if it
+	// is nil.
+	Authenticate func(room, key string) bool
+
+	// Listeners returns how many people are listening to the audio of room.
+	// The audio is served outside of the chat, so presence only counts chat
+	// connections if it is nil.
+	Listeners func(room string) int
+
+	// AllowRoom reports whether a room may be joined. Rooms that are open
+	// are closed once it no longer allows them. Any room is allowed if it is
+	// nil.
+	AllowRoom func(room string) bool
+
+	// OnMessage, if set, sees every message the hub sends to a room. It is
+	// called from the hub's goroutine and must not block.
+	OnMessage func(room string, m Message)
+
 	// Registered connections.
 	rooms map[string]map[*connection]bool
 
@@ -140,22 +284,82 @@ type hub struct {
 
 	// Unregister requests from connections.
 	unregister chan subscription
+
+	// Messages for a single connection.
+	reply chan reply
+
+	// System messages from outside of the chat.
+	notices chan notice
+
+	// Recent messages of each room, oldest first.
+	history map[string][]Message
+
+	// Rooms whose history changed since it was last saved.
+	dirty map[string]bool
+
+	// Mutes, bans and slow mode of each room.
+	mods map[string]*moderation
+
+	// Requests for the presence of a room.
+	query chan presenceQuery
+
+	// Nicknames whose connection was dropped, to announce they left.
+	departed []departure
+
+	// The last presence snapshot sent to each room.
+	sent map[string]Presence
+
+	// Closed once Run has returned.
+	done chan struct{}
 }
 
-var h = hub{
-	broadcast:  make(chan message),
-	register:   make(chan subscription),
-	unregister: make(chan subscription),
-	rooms:      make(map[string]map[*connection]bool),
+// NewHub returns a hub with the default settings
+func NewHub() *Hub {
+	return &Hub{
+		HistorySize: DefaultHistorySize,
+		HistoryAge:  DefaultHistoryAge,
+		RateLimit:   DefaultRateLimit,
+		RatePeriod:  DefaultRatePeriod,
+		broadcast:   make(chan message),
+		register:    make(chan subscription),
+		unregister:  make(chan subscription),
+		reply:       make(chan reply),
+		notices:     make(chan notice),
+		rooms:       make(map[string]map[*connection]bool),
+		history:     make(map[string][]Message),
+		dirty:       make(map[string]bool),
+		mods:        make(map[string]*moderation),
+		query:       make(chan presenceQuery),
+		sent:        make(map[string]Presence),
+		done:        make(chan struct{}),
+	}
 }
 
-func Run() {
-	h.Run()
+// submit hands v to the hub on ch, unless the hub has shut down. It reports
+// whether the hub took it.
+func submit[T any](h *Hub, ch chan T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-h.done:
+		return false
+	}
 }
 
-func (h *hub) Run() {
+// Run handles the rooms until ctx is done, then saves the histories and
+// disconnects everyone. A hub cannot be run again after it returns.
+func (h *Hub) Run(ctx context.Context) {
+	defer close(h.done)
+	h.loadHistory()
+	ticker := time.NewTicker(historySavePeriod)
+	defer ticker.Stop()
+	presenceTicker := time.NewTicker(presencePeriod)
+	defer presenceTicker.Stop()
 	for {
 		select {
+		case <-ctx.Done():
+			h.shutdown()
+			return
 		case s := <-h.register:
 			connections := h.rooms[s.room]
 			if connections == nil {
@@ -163,31 +367,257 @@ func (h *hub) Run() {
 				h.rooms[s.room] = connections
 			}
 			h.rooms[s.room][s.conn] = true
-		case s := <-h.unregister:
-			connections := h.rooms[s.room]
-			if connections != nil {
-				if _, ok := connections[s.conn]; ok {
-					delete(connections, s.conn)
-					close(s.conn.send)
-					if len(connections) == 0 {
-						delete(h.rooms, s.room)
-					}
-				}
+			if matches(h.moderation(s.room).banned, s.conn, s.conn.name) {
+				h.replyTo(s, Message{Type: TypeError, Text: "you are banned from this room"})
+				// never announced as joining, so not as leaving either
+				s.conn.name = ""
+				h.drop(s.room, s.conn)
+				continue
 			}
-		case m := <-h.broadcast:
-			log.Debugf("data: %+v", m)
-			connections := h.rooms[m.room]
-			for c := range connections {
+			if s.conn.name != "" && h.reserved(s.room, s.conn.name) {
+				h.replyTo(s, Message{Type: TypeError, Text: fmt.Sprintf("'%s' is the host's name, choose another", s.conn.name)})
+				s.conn.name = ""
+			}
+			if s.conn.via != "" {
+				// whatever relay is on the other end was not here for the history
+				continue
+			}
+			h.prune(s.room)
+			for _, m := range h.history[s.room] {
+				b, _ := json.Marshal(m)
 				select {
-				case c.send <- m.data:
+				case s.conn.send <- b:
 				default:
-					close(c.send)
-					delete(connections, c)
-					if len(connections) == 0 {
-						delete(h.rooms, m.room)
-					}
 				}
 			}
+			if s.conn.name != "" && h.named(s.room, s.conn.name) == 1 {
+				h.send(s.room, Message{Type: TypeJoin, Name: s.conn.name})
+			}
+			p := h.presence(s.room)
+			h.replyTo(s, Message{Type: TypePresence, Presence: &p})
+		case s := <-h.unregister:
+			h.drop(s.room, s.conn)
+		case r := <-h.reply:
+			h.replyTo(r.sub, r.msg)
+		case n := <-h.notices:
+			if _, ok := h.rooms[n.room]; ok {
+				h.send(n.room, Message{Type: TypeSystem, Text: n.text})
+			}
+		case m := <-h.broadcast:
+			log.Debugf("%s in '%s' from '%s'", m.msg.Type, m.sub.room, m.msg.Name)
+			h.handle(m)
+		case q := <-h.query:
+			q.result <- h.presence(q.room)
+		case <-ticker.C:
+			h.closeRooms()
+			h.saveHistory()
+		case <-presenceTicker.C:
+			h.sendPresence()
+		}
+		h.announceDepartures()
+	}
+}
+
+// shutdown saves the histories and disconnects everyone
+func (h *Hub) shutdown() {
+	for room, connections := range h.rooms {
+		h.send(room, Message{Type: TypeSystem, Text: "the chat is shutting down"})
+		for c := range connections {
+			h.drop(room, c)
+		}
+	}
+	h.departed = nil
+	h.saveHistory()
+}
+
+// Announce sends a system message to everyone in room, if anyone is there.
+// It reports whether the hub was still running.
+func (h *Hub) Announce(room, text string) bool {
+	return submit(h, h.notices, notice{room, truncate(sanitize(text), maxTextLength)})
+}
+
+// send stamps a message with an ID and the time and sends it to everyone in
+// the room
+func (h *Hub) send(room string, m Message) Message {
+	m.ID = newID()
+	m.Time = time.Now()
+	if h.OnMessage != nil {
+		h.OnMessage(room, m)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Error(err)
+		return m
+	}
+	for c := range h.rooms[room] {
+		select {
+		case c.send <- data:
+		default:
+			// too slow to keep up
+			h.drop(room, c)
+		}
+	}
+	return m
+}
+
+// replyTo sends a message to a single subscription
+func (h *Hub) replyTo(s subscription, m Message) {
+	if !h.rooms[s.room][s.conn] {
+		return
+	}
+	m.Time = time.Now()
+	b, _ := json.Marshal(m)
+	select {
+	case s.conn.send <- b:
+	default:
+	}
+}
+
+// drop disconnects a connection from a room. A connection can be dropped
+// for being banned or too slow and then unregister when its websocket
+// closes, so only the first drop closes its send channel.
+func (h *Hub) drop(room string, c *connection) {
+	connections := h.rooms[room]
+	if _, ok := connections[c]; !ok {
+		return
+	}
+	delete(connections, c)
+	close(c.send)
+	if c.name != "" {
+		h.departed = append(h.departed, departure{room, c.name})
+	}
+	if len(connections) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// closeRooms disconnects everyone from rooms that are no longer allowed
+func (h *Hub) closeRooms() {
+	if h.AllowRoom == nil {
+		return
+	}
+	for room, connections := range h.rooms {
+		if h.people(room) == 0 || h.AllowRoom(room) {
+			continue
+		}
+		log.Debugf("closing '%s'", room)
+		h.send(room, Message{Type: TypeSystem, Text: "the stream has ended, this chat is closed"})
+		for c := range connections {
+			if c.via != "" {
+				// relays wait for the room to open again
+				continue
+			}
+			// leaving together, nobody is left to tell
+			c.name = ""
+			h.drop(room, c)
+		}
+		delete(h.mods, room)
+	}
+}
+
+// remember adds a message to its room's history
+func (h *Hub) remember(room string, m Message) {
+	if h.HistorySize <= 0 {
+		return
+	}
+	h.history[room] = append(h.history[room], m)
+	h.prune(room)
+	h.dirty[room] = true
+}
+
+// prune drops messages that are too old or beyond HistorySize
+func (h *Hub) prune(room string) {
+	entries := h.history[room]
+	if len(entries) > h.HistorySize {
+		entries = entries[len(entries)-max(h.HistorySize, 0):]
+	}
+	if h.HistoryAge > 0 {
+		cutoff := time.Now().Add(-h.HistoryAge)
+		i := 0
+		for i < len(entries) && entries[i].Time.Before(cutoff) {
+			i++
+		}
+		entries = entries[i:]
+	}
+	if len(entries) != len(h.history[room]) {
+		h.dirty[room] = true
+	}
+	if len(entries) == 0 {
+		delete(h.history, room)
+	} else {
+		h.history[room] = entries
+	}
+}
+
+// historyFile is where the history of a room is saved
+func (h *Hub) historyFile(room string) string {
+	return filepath.Join(h.HistoryFolder, url.PathEscape(room)+".json")
+}
+
+// loadHistory reads the room histories saved in HistoryFolder
+func (h *Hub) loadHistory() {
+	if h.HistoryFolder == "" {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(h.HistoryFolder, "*.json"))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, fname := range files {
+		room, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(fname), ".json"))
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(fname)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		var saved []savedMessage
+		if err = json.Unmarshal(b, &saved); err != nil {
+			log.Errorf("%s: %s", fname, err)
+			continue
+		}
+		messages := make([]Message, len(saved))
+		for i, m := range saved {
+			messages[i] = m.Message
+			messages[i].ip = m.IP
+		}
+		h.history[room] = messages
+		h.prune(room)
+	}
+	log.Debugf("loaded history of %d rooms", len(h.history))
+}
+
+// saveHistory writes the histories that changed to HistoryFolder
+func (h *Hub) saveHistory() {
+	if h.HistoryFolder == "" {
+		return
+	}
+	os.MkdirAll(h.HistoryFolder, os.ModePerm)
+	for room := range h.dirty {
+		h.prune(room)
+		var err error
+		if entries, ok := h.history[room]; ok {
+			saved := make([]savedMessage, len(entries))
+			for i, m := range entries {
+				saved[i] = savedMessage{m, m.ip}
+			}
+			var b []byte
+			b, err = json.Marshal(saved)
+			if err == nil {
+				err = os.WriteFile(h.historyFile(room), b, 0644)
+			}
+		} else {
+			err = os.Remove(h.historyFile(room))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Error(err)
 		}
+		delete(h.dirty, room)
 	}
 }
@@ -0,0 +1,382 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startHub runs a hub behind a test server until the test ends
+func startHub(t *testing.T, configure func(h *Hub)) (h *Hub, srv *httptest.Server, stop context.CancelFunc) {
+	h = NewHub()
+	if configure != nil {
+		configure(h)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	go h.Run(ctx)
+	srv = httptest.NewServer(h)
+	t.Cleanup(func() {
+		stop()
+		srv.Close()
+		// the hub saves its history on the way out, which has to be done
+		// before the test's folders are removed
+		<-h.done
+	})
+	return
+}
+
+func dial(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?" + query
+	ws, resp, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial %s: %s (%d)", query, err, status)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func write(t *testing.T, ws *websocket.Conn, m Message) {
+	t.Helper()
+	b, _ := json.Marshal(m)
+	if err := ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next reads messages until one has the given type
+func next(t *testing.T, ws *websocket.Conn, typ string) Message {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, b, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %s", typ, err)
+		}
+		var m Message
+		if err = json.Unmarshal(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type == typ {
+			return m
+		}
+	}
+}
+
+func TestRegisterReplaysHistory(t *testing.T) {
+	_, srv, _ := startHub(t, nil)
+	a := dial(t, srv, "room=show")
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "first"})
+	next(t, a, TypeMessage)
+
+	b := dial(t, srv, "room=show&name=bob")
+	if m := next(t, b, TypeMessage); m.Text != "first" || m.Name != "ann" || m.ID == "" {
+		t.Errorf("replayed %+v", m)
+	}
+	p := next(t, b, TypePresence).Presence
+	if p == nil || p.Chatters != 2 || len(p.Names) != 2 {
+		t.Errorf("presence %+v", p)
+	}
+	if m := next(t, a, TypeJoin); m.Name != "bob" {
+		t.Errorf("joined %+v", m)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	h, srv, _ := startHub(t, nil)
+	a := dial(t, srv, "room=show")
+	b := dial(t, srv, "room=show")
+	other := dial(t, srv, "room=other")
+
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "hello <b>"})
+	for _, ws := range []*websocket.Conn{a, b} {
+		if m := next(t, ws, TypeMessage); m.Text != "hello <b>" {
+			t.Errorf("received %+v", m)
+		}
+	}
+
+	write(t, other, Message{Type: TypeMessage, Name: "olga", Text: "elsewhere"})
+	next(t, other, TypeMessage)
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "again"})
+	if m := next(t, b, TypeMessage); m.Text != "again" {
+		t.Errorf("message from another room leaked: %+v", m)
+	}
+
+	if p := h.Presence("other"); p.Chatters != 1 || len(p.Names) != 1 || p.Names[0] != "olga" {
+		t.Errorf("presence %+v", p)
+	}
+}
+
+func TestRejectsRooms(t *testing.T) {
+	_, srv, _ := startHub(t, func(h *Hub) {
+		h.AllowRoom = func(room string) bool { return room == "show" }
+	})
+	for query, status := range map[string]int{
+		"":             http.StatusBadRequest,
+		"room=nothing": http.StatusNotFound,
+	} {
+		resp, err := http.Get(srv.URL + "/ws?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%q: got %d, want %d", query, resp.StatusCode, status)
+		}
+	}
+	dial(t, srv, "room=show")
+}
+
+func TestInvalidMessage(t *testing.T) {
+	_, srv, _ := startHub(t, nil)
+	a := dial(t, srv, "room=show")
+	a.WriteMessage(websocket.TextMessage, []byte(`{"type":"message","name":"ann","text":"hi","html":"<b>"}`))
+	if m := next(t, a, TypeError); m.Text != "malformed message" {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	h, srv, _ := startHub(t, func(h *Hub) {
+		h.RateLimit = 0
+	})
+	a := dial(t, srv, "room=show")
+
+	// a connection that never reads, with room for a single message
+	slow := subscription{&connection{hub: h, send: make(chan []byte, 1)}, "show"}
+	submit(h, h.register, slow)
+
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "one"})
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "two"})
+	next(t, a, TypeMessage)
+	next(t, a, TypeMessage)
+
+	// whatever it got before it fell behind, and then it was closed
+	for range slow.conn.send {
+	}
+	// unregistering after being dropped must not close it again
+	submit(h, h.unregister, slow)
+	if p := h.Presence("show"); p.Chatters != 1 {
+		t.Errorf("slow connection still present: %+v", p)
+	}
+}
+
+func TestBanDisconnects(t *testing.T) {
+	_, srv, _ := startHub(t, func(h *Hub) {
+		h.Authenticate = func(room, key string) bool { return key == "secret" }
+	})
+	host := dial(t, srv, "room=show&key=secret&name=dj")
+	if m := next(t, host, TypeAuth); m.Name != "dj" {
+		t.Fatalf("auth %+v", m)
+	}
+	bob := dial(t, srv, "room=show")
+	write(t, bob, Message{Type: TypeMessage, Name: "bob", Text: "spam"})
+	spam := next(t, host, TypeMessage)
+
+	write(t, host, Message{Type: TypeBan, Target: spam.ID, Duration: 60})
+	next(t, host, TypeSystem)
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := bob.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if m := next(t, host, TypeLeave); m.Name != "bob" {
+		t.Errorf("left %+v", m)
+	}
+
+	// banned by address, so coming back under another name does not help
+	again := dial(t, srv, "room=show&name=robert")
+	if m := next(t, again, TypeError); !strings.Contains(m.Text, "banned") {
+		t.Errorf("got %+v", m)
+	}
+
+	write(t, host, Message{Type: TypeMessage, Name: "dj", Text: "still here"})
+	if m := next(t, host, TypeMessage); !m.Host {
+		t.Errorf("host message not marked: %+v", m)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h, srv, stop := startHub(t, nil)
+	a := dial(t, srv, "room=show")
+	next(t, a, TypePresence)
+
+	stop()
+	if m := next(t, a, TypeSystem); !strings.Contains(m.Text, "shutting down") {
+		t.Errorf("got %+v", m)
+	}
+	if _, _, err := a.ReadMessage(); err == nil {
+		t.Error("connection still open after shutdown")
+	}
+
+	select {
+	case <-h.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("hub did not stop")
+	}
+	resp, err := http.Get(srv.URL + "/ws?room=show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %d after shutdown", resp.StatusCode)
+	}
+	if p := h.Presence("show"); p.Chatters != 0 {
+		t.Errorf("presence after shutdown %+v", p)
+	}
+}
+
+func TestHistoryFolder(t *testing.T) {
+	folder := t.TempDir()
+	h, srv, stop := startHub(t, func(h *Hub) {
+		h.HistoryFolder = folder
+	})
+	a := dial(t, srv, "room=show")
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "remember me"})
+	next(t, a, TypeMessage)
+	stop()
+	<-h.done
+
+	// a new hub picks up where the last one left off
+	_, srv, _ = startHub(t, func(h *Hub) {
+		h.HistoryFolder = folder
+	})
+	b := dial(t, srv, "room=show")
+	if m := next(t, b, TypeMessage); m.Text != "remember me" {
+		t.Errorf("replayed %+v", m)
+	}
+}
+
+func TestBanAfterRestart(t *testing.T) {
+	folder := t.TempDir()
+	authenticate := func(h *Hub) {
+		h.HistoryFolder = folder
+		h.Authenticate = func(room, key string) bool { return key == "secret" }
+	}
+	h, srv, stop := startHub(t, authenticate)
+	bob := dial(t, srv, "room=show")
+	write(t, bob, Message{Type: TypeMessage, Name: "bob", Text: "spam"})
+	spam := next(t, bob, TypeMessage)
+	stop()
+	<-h.done
+
+	// the address is kept, but never shown
+	b, _ := os.ReadFile(filepath.Join(folder, "show.json"))
+	if !strings.Contains(string(b), `"ip":"127.0.0.1"`) {
+		t.Errorf("saved %s", b)
+	}
+	_, srv, _ = startHub(t, authenticate)
+	host := dial(t, srv, "room=show&key=secret&name=dj")
+	if m := next(t, host, TypeMessage); m.ID != spam.ID {
+		t.Errorf("replayed %+v", m)
+	}
+	write(t, host, Message{Type: TypeBan, Target: spam.ID, Duration: 60})
+	if m := next(t, host, TypeSystem); !strings.Contains(m.Text, "bob was banned") {
+		t.Errorf("got %+v", m)
+	}
+	again := dial(t, srv, "room=show&name=robert")
+	if m := next(t, again, TypeError); !strings.Contains(m.Text, "banned") {
+		t.Errorf("got %+v", m)
+	}
+
+	// history saved before addresses were kept cannot be acted on, and the
+	// host hears so
+	old := filepath.Join(t.TempDir(), "old")
+	os.MkdirAll(old, os.ModePerm)
+	os.WriteFile(filepath.Join(old, "show.json"), []byte(`[{"id":"1","type":"message","name":"eve","text":"hi","time":"`+
+		time.Now().Format(time.RFC3339)+`"}]`), 0644)
+	_, srv, _ = startHub(t, func(h *Hub) {
+		authenticate(h)
+		h.HistoryFolder = old
+	})
+	host = dial(t, srv, "room=show&key=secret&name=dj")
+	next(t, host, TypeMessage)
+	write(t, host, Message{Type: TypeMute, Target: "1", Duration: 60})
+	if m := next(t, host, TypeError); !strings.Contains(m.Text, "by name instead") {
+		t.Errorf("got %+v", m)
+	}
+	write(t, host, Message{Type: TypeBan, Target: "gone", Duration: 60})
+	if m := next(t, host, TypeError); !strings.Contains(m.Text, "gone") {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestJoinWithHostName(t *testing.T) {
+	h, srv, _ := startHub(t, func(h *Hub) {
+		h.Authenticate = func(room, key string) bool { return key == "secret" }
+	})
+	host := dial(t, srv, "room=show&key=secret&name=dj")
+	next(t, host, TypeAuth)
+
+	// joining under the host's name is refused like taking it later is
+	fake := dial(t, srv, "room=show&name=DJ")
+	if m := next(t, fake, TypeError); !strings.Contains(m.Text, "host's name") {
+		t.Errorf("got %+v", m)
+	}
+	if p := h.Presence("show"); p.Chatters != 2 || len(p.Names) != 1 {
+		t.Errorf("presence %+v", p)
+	}
+
+	// the host's own second connection keeps the name
+	again := dial(t, srv, "room=show&key=secret&name=dj")
+	if m := next(t, again, TypeAuth); m.Name != "dj" {
+		t.Errorf("auth %+v", m)
+	}
+}
+
+// replayed reads what a new connection is sent before the presence of the
+// room, which is the room's history
+func replayed(t *testing.T, ws *websocket.Conn) (texts []string) {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var m Message
+		if err := ws.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type == TypePresence {
+			return
+		}
+		texts = append(texts, m.Text)
+	}
+}
+
+func TestHistoryLimits(t *testing.T) {
+	_, srv, _ := startHub(t, func(h *Hub) {
+		h.HistorySize = 2
+		h.RateLimit = 0
+	})
+	a := dial(t, srv, "room=show")
+	for _, text := range []string{"one", "two", "three"} {
+		write(t, a, Message{Type: TypeMessage, Name: "ann", Text: text})
+		next(t, a, TypeMessage)
+	}
+	if got := replayed(t, dial(t, srv, "room=show")); strings.Join(got, " ") != "two three" {
+		t.Errorf("replayed %q", got)
+	}
+
+	_, srv, _ = startHub(t, func(h *Hub) {
+		h.HistoryAge = 100 * time.Millisecond
+	})
+	a = dial(t, srv, "room=show")
+	write(t, a, Message{Type: TypeMessage, Name: "ann", Text: "soon gone"})
+	next(t, a, TypeMessage)
+	time.Sleep(200 * time.Millisecond)
+	if got := replayed(t, dial(t, srv, "room=show")); len(got) != 0 {
+		t.Errorf("replayed %q", got)
+	}
+}
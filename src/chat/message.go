@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Types of Message
+const (
+	// TypeMessage is a chat message written by someone in the room
+	TypeMessage = "message"
+	// TypeError tells a client why its message was not sent
+	TypeError = "error"
+	// TypeSystem is a notice from the server to the room
+	TypeSystem = "system"
+	// TypeAuth authenticates a connection with the stream key of the room,
+	// reserving Name for the host while it is connected
+	TypeAuth = "auth"
+	// TypeDelete removes the message with ID Target
+	TypeDelete = "delete"
+	// TypeMute stops Name, or whoever sent message Target, from chatting
+	// for Duration seconds. A Duration of zero lifts the mute.
+	TypeMute = "mute"
+	// TypeBan is like TypeMute but also disconnects the banned person
+	TypeBan = "ban"
+	// TypeSlow allows one message every Duration seconds, zero turns it off
+	TypeSlow = "slow"
+	// TypePresence carries a snapshot of who is in the room. Clients send
+	// it to ask for one.
+	TypePresence = "presence"
+	// TypeJoin and TypeLeave announce that Name joined or left the room
+	TypeJoin  = "join"
+	TypeLeave = "leave"
+)
+
+const (
+	maxNameLength = 32
+	maxTextLength = 500
+	// longest mute, ban or slow mode, in seconds
+	maxDuration = 30 * 24 * 60 * 60
+)
+
+// Message is the JSON sent over the chat websocket. Clients set Type and
+// the fields that type uses, the server assigns the ID and Time.
+type Message struct {
+	ID       string    `json:"id,omitempty"`
+	Type     string    `json:"type"`
+	Name     string    `json:"name,omitempty"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target,omitempty"`
+	Duration int       `json:"duration,omitempty"`
+	Key      string    `json:"key,omitempty"`
+	// Host is set by the server on messages from the room's host
+	Host bool `json:"host,omitempty"`
+	// Via is set by the server on messages relayed from outside of the
+	// chat, like "irc"
+	Via string `json:"via,omitempty"`
+	// Presence is set by the server on TypePresence messages
+	Presence *Presence `json:"presence,omitempty"`
+
+	// ip is the address of whoever sent the message
+	ip string
+}
+
+// savedMessage is a message as it is kept in HistoryFolder. The sender's
+// address is never sent to clients, but is kept so that the host can still
+// mute or ban them after a restart.
+type savedMessage struct {
+	Message
+	IP string `json:"ip,omitempty"`
+}
+
+// parseMessage validates a message sent by a client and sanitizes its text
+func parseMessage(b []byte) (m Message, err error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err = d.Decode(&m); err != nil {
+		err = fmt.Errorf("malformed message")
+		return
+	}
+	m.ID = ""
+	m.Time = time.Time{}
+	m.Host = false
+	m.Via = ""
+	m.Presence = nil
+	m.Name = sanitize(m.Name)
+	m.Text = sanitize(m.Text)
+	if m.Type != TypeAuth {
+		m.Key = ""
+	}
+	switch m.Type {
+	case TypeMessage:
+		switch {
+		case m.Name == "":
+			err = fmt.Errorf("name cannot be empty")
+		case utf8.RuneCountInString(m.Name) > maxNameLength:
+			err = fmt.Errorf("name is longer than %d characters", maxNameLength)
+		case m.Text == "":
+			err = fmt.Errorf("message cannot be empty")
+		case utf8.RuneCountInString(m.Text) > maxTextLength:
+			err = fmt.Errorf("message is longer than %d characters", maxTextLength)
+		}
+	case TypeAuth:
+		if m.Key == "" {
+			err = fmt.Errorf("no stream key given")
+		} else if utf8.RuneCountInString(m.Name) > maxNameLength {
+			err = fmt.Errorf("name is longer than %d characters", maxNameLength)
+		}
+	case TypeDelete:
+		if m.Target == "" {
+			err = fmt.Errorf("no message to delete")
+		}
+	case TypeMute, TypeBan:
+		if m.Target == "" && m.Name == "" {
+			err = fmt.Errorf("no one to %s", m.Type)
+		}
+	case TypeSlow, TypePresence:
+	default:
+		err = fmt.Errorf("unknown message type '%s'", m.Type)
+	}
+	if err == nil && (m.Duration < 0 || m.Duration > maxDuration) {
+		err = fmt.Errorf("duration must be between 0 and %d seconds", maxDuration)
+	}
+	return
+}
+
+// sanitize drops invalid UTF-8, control characters and bidirectional
+// overrides, and collapses whitespace to single spaces
+func sanitize(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,41 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	long := strings.Repeat("x", maxTextLength+1)
+	for _, tc := range []struct {
+		in   string
+		err  string
+		want Message
+	}{
+		{`{"type":"message","name":" ann ","text":"hi\tthere‮"}`, "", Message{Type: TypeMessage, Name: "ann", Text: "hi there"}},
+		// whatever only the server sets is dropped
+		{`{"type":"message","name":"ann","text":"hi","id":"x","host":true,"via":"irc","key":"k"}`, "", Message{Type: TypeMessage, Name: "ann", Text: "hi"}},
+		{`{"type":"auth","key":"secret","name":"dj"}`, "", Message{Type: TypeAuth, Name: "dj", Key: "secret"}},
+		{`{"type":"slow","duration":30}`, "", Message{Type: TypeSlow, Duration: 30}},
+		{`{"type":"message","name":"ann","text":"hi","html":"<b>"}`, "malformed", Message{}},
+		{`not json`, "malformed", Message{}},
+		{`{"type":"message","text":"hi"}`, "name cannot be empty", Message{}},
+		{`{"type":"message","name":"ann","text":" \n "}`, "message cannot be empty", Message{}},
+		{`{"type":"message","name":"ann","text":"` + long + `"}`, "longer than", Message{}},
+		{`{"type":"message","name":"` + strings.Repeat("n", maxNameLength+1) + `","text":"hi"}`, "longer than", Message{}},
+		{`{"type":"auth"}`, "no stream key", Message{}},
+		{`{"type":"delete"}`, "no message to delete", Message{}},
+		{`{"type":"ban","duration":60}`, "no one to ban", Message{}},
+		{`{"type":"mute","name":"bob","duration":-1}`, "duration", Message{}},
+		{`{"type":"shout","text":"hi"}`, "unknown message type", Message{}},
+	} {
+		m, err := parseMessage([]byte(tc.in))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: %v", tc.in, err)
+			}
+		} else if err != nil || m != tc.want {
+			t.Errorf("%q: %+v, %v", tc.in, m, err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// moderation is the state of a room that its host controls
+type moderation struct {
+	// slow is how long everyone but the host waits between messages
+	slow time.Duration
+	// muted and banned map "name:<nickname>" or "ip:<address>" to when the
+	// mute or ban ends
+	muted  map[string]time.Time
+	banned map[string]time.Time
+}
+
+func (h *Hub) moderation(room string) *moderation {
+	mod, ok := h.mods[room]
+	if !ok {
+		mod = &moderation{
+			muted:  make(map[string]time.Time),
+			banned: make(map[string]time.Time),
+		}
+		h.mods[room] = mod
+	}
+	return mod
+}
+
+// matches reports whether the connection, or the name it is using, is in
+// list and has not yet expired
+func matches(list map[string]time.Time, c *connection, name string) bool {
+	now := time.Now()
+	keys := []string{"ip:" + c.ip}
+	if name != "" {
+		keys = append(keys, "name:"+strings.ToLower(name))
+	}
+	for _, key := range keys {
+		if until, ok := list[key]; ok {
+			if now.Before(until) {
+				return true
+			}
+			delete(list, key)
+		}
+	}
+	return false
+}
+
+// allow checks slow mode and the hub's rate limit before c sends a message
+func (c *connection) allow(slow time.Duration) (err error) {
+	limit, period := c.hub.RateLimit, c.hub.RatePeriod
+	now := time.Now()
+	if wait := slow - now.Sub(c.lastSent); wait > 0 {
+		return fmt.Errorf("slow mode is on, wait %s", wait.Round(time.Second))
+	}
+	if limit > 0 && period > 0 {
+		refill := float64(now.Sub(c.lastRefill)) / float64(period) * float64(limit)
+		c.tokens = min(c.tokens+refill, float64(limit))
+		c.lastRefill = now
+		if c.tokens < 1 {
+			return fmt.Errorf("you are sending messages too quickly")
+		}
+		c.tokens--
+	}
+	c.lastSent = now
+	return
+}
+
+// handle acts on a message from a subscription
+func (h *Hub) handle(m message) {
+	s := m.sub
+	c := s.conn
+	if !h.rooms[s.room][c] {
+		return
+	}
+	mod := h.moderation(s.room)
+	switch m.msg.Type {
+	case TypeMessage:
+		if !c.host {
+			if matches(mod.banned, c, m.msg.Name) {
+				h.replyTo(s, Message{Type: TypeError, Text: "you are banned from this room"})
+				if c.via == "" {
+					h.drop(s.room, c)
+				}
+				return
+			}
+			if matches(mod.muted, c, m.msg.Name) {
+				h.replyTo(s, Message{Type: TypeError, Text: "you are muted"})
+				return
+			}
+			if h.reserved(s.room, m.msg.Name) {
+				h.replyTo(s, Message{Type: TypeError, Text: fmt.Sprintf("'%s' is the host's name, choose another", m.msg.Name)})
+				return
+			}
+			// a relay speaks for many people, who are limited where they are
+			if c.via == "" {
+				if err := c.allow(mod.slow); err != nil {
+					h.replyTo(s, Message{Type: TypeError, Text: err.Error()})
+					return
+				}
+			}
+		}
+		if c.via == "" {
+			h.rename(s, m.msg.Name)
+		}
+		m.msg.Via = c.via
+		m.msg.Host = c.host
+		m.msg.ip = c.ip
+		h.remember(s.room, h.send(s.room, m.msg))
+	case TypeAuth:
+		if h.Authenticate == nil || !h.Authenticate(s.room, m.msg.Key) {
+			h.replyTo(s, Message{Type: TypeError, Text: "wrong stream key"})
+			return
+		}
+		c.host = true
+		if m.msg.Name != "" {
+			h.rename(s, m.msg.Name)
+		}
+		h.replyTo(s, Message{Type: TypeAuth, Name: c.name, Text: "you are the host of this room"})
+	case TypePresence:
+		p := h.presence(s.room)
+		h.replyTo(s, Message{Type: TypePresence, Presence: &p})
+	default:
+		if !c.host {
+			h.replyTo(s, Message{Type: TypeError, Text: "only the host can do that"})
+			return
+		}
+		if err := h.moderate(s.room, m.msg); err != nil {
+			h.replyTo(s, Message{Type: TypeError, Text: err.Error()})
+		}
+	}
+}
+
+// moderate carries out a host's action and tells the room about it. The
+// error is for the host, when the action could not be carried out.
+func (h *Hub) moderate(room string, m Message) (err error) {
+	mod := h.moderation(room)
+	switch m.Type {
+	case TypeDelete:
+		h.forget(room, m.Target)
+		h.send(room, Message{Type: TypeDelete, Target: m.Target})
+	case TypeMute, TypeBan:
+		key, name := "name:"+strings.ToLower(m.Name), m.Name
+		if m.Target != "" {
+			target, ok := h.find(room, m.Target)
+			if !ok {
+				return fmt.Errorf("that message is gone, %s them by name instead", m.Type)
+			} else if target.ip == "" {
+				return fmt.Errorf("whoever sent that message cannot be told apart anymore, %s them by name instead", m.Type)
+			}
+			key, name = "ip:"+target.ip, target.Name
+		}
+		list, verb := mod.muted, "muted"
+		if m.Type == TypeBan {
+			list, verb = mod.banned, "banned"
+		}
+		duration := time.Duration(m.Duration) * time.Second
+		text := fmt.Sprintf("%s is no longer %s", name, verb)
+		if duration > 0 {
+			list[key] = time.Now().Add(duration)
+			text = fmt.Sprintf("%s was %s for %s", name, verb, duration)
+		} else {
+			delete(list, key)
+		}
+		if m.Type == TypeBan && duration > 0 {
+			for c := range h.rooms[room] {
+				if !c.host && matches(mod.banned, c, c.name) {
+					h.drop(room, c)
+				}
+			}
+		}
+		h.send(room, Message{Type: TypeSystem, Text: text})
+	case TypeSlow:
+		mod.slow = time.Duration(m.Duration) * time.Second
+		text := "slow mode is off"
+		if mod.slow > 0 {
+			text = fmt.Sprintf("slow mode is on, one message every %s", mod.slow)
+		}
+		h.send(room, Message{Type: TypeSlow, Duration: m.Duration, Text: text})
+	}
+	return
+}
+
+// reserved reports whether name is used by a host connected to the room
+func (h *Hub) reserved(room, name string) bool {
+	for c := range h.rooms[room] {
+		if c.host && c.name != "" && strings.EqualFold(c.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// find looks up a message in the history of a room
+func (h *Hub) find(room, id string) (m Message, ok bool) {
+	for _, m = range h.history[room] {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return
+}
+
+// forget removes a message from the history of a room
+func (h *Hub) forget(room, id string) {
+	messages := h.history[room]
+	for i, m := range messages {
+		if m.ID == id {
+			h.history[room] = append(messages[:i:i], messages[i+1:]...)
+			h.dirty[room] = true
+			return
+		}
+	}
+}
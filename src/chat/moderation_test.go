@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModeration(t *testing.T) {
+	_, srv, _ := startHub(t, func(h *Hub) {
+		h.Authenticate = func(room, key string) bool { return key == "secret" }
+		h.RateLimit = 0
+	})
+	host := dial(t, srv, "room=show&key=secret&name=dj")
+	next(t, host, TypeAuth)
+	bob := dial(t, srv, "room=show")
+
+	// only the host moderates
+	write(t, bob, Message{Type: TypeSlow, Duration: 60})
+	if m := next(t, bob, TypeError); m.Text != "only the host can do that" {
+		t.Errorf("got %+v", m)
+	}
+
+	// a deleted message goes for everyone, and from the history
+	write(t, bob, Message{Type: TypeMessage, Name: "bob", Text: "oops"})
+	oops := next(t, host, TypeMessage)
+	write(t, host, Message{Type: TypeDelete, Target: oops.ID})
+	if m := next(t, bob, TypeDelete); m.Target != oops.ID {
+		t.Errorf("deleted %+v", m)
+	}
+	if got := replayed(t, dial(t, srv, "room=show")); len(got) != 0 {
+		t.Errorf("history still has %q", got)
+	}
+
+	// a mute by name holds until it is lifted
+	write(t, host, Message{Type: TypeMute, Name: "Bob", Duration: 600})
+	if m := next(t, bob, TypeSystem); m.Text != "Bob was muted for 10m0s" {
+		t.Errorf("got %+v", m)
+	}
+	write(t, bob, Message{Type: TypeMessage, Name: "bob", Text: "hello?"})
+	if m := next(t, bob, TypeError); m.Text != "you are muted" {
+		t.Errorf("got %+v", m)
+	}
+	write(t, host, Message{Type: TypeMute, Name: "bob"})
+	if m := next(t, bob, TypeSystem); m.Text != "bob is no longer muted" {
+		t.Errorf("got %+v", m)
+	}
+
+	// slow mode holds back everyone but the host
+	write(t, host, Message{Type: TypeSlow, Duration: 30})
+	if m := next(t, bob, TypeSlow); m.Duration != 30 {
+		t.Errorf("got %+v", m)
+	}
+	// bob's last message was just now
+	write(t, bob, Message{Type: TypeMessage, Name: "bob", Text: "again"})
+	if m := next(t, bob, TypeError); !strings.HasPrefix(m.Text, "slow mode is on") {
+		t.Errorf("got %+v", m)
+	}
+	write(t, host, Message{Type: TypeMessage, Name: "dj", Text: "one"})
+	next(t, host, TypeMessage)
+	write(t, host, Message{Type: TypeMessage, Name: "dj", Text: "two"})
+	if m := next(t, host, TypeMessage); m.Text != "two" {
+		t.Errorf("host slowed down: %+v", m)
+	}
+}
+
+func TestHostName(t *testing.T) {
+	_, srv, _ := startHub(t, func(h *Hub) {
+		h.Authenticate = func(room, key string) bool { return key == "secret" }
+	})
+	bob := dial(t, srv, "room=show")
+	write(t, bob, Message{Type: TypeAuth, Key: "guess", Name: "dj"})
+	if m := next(t, bob, TypeError); m.Text != "wrong stream key" {
+		t.Errorf("got %+v", m)
+	}
+
+	host := dial(t, srv, "room=show")
+	write(t, host, Message{Type: TypeAuth, Key: "secret", Name: "dj"})
+	next(t, host, TypeAuth)
+	write(t, host, Message{Type: TypeMessage, Name: "dj", Text: "welcome"})
+	if m := next(t, bob, TypeMessage); !m.Host || m.Name != "dj" {
+		t.Errorf("host message %+v", m)
+	}
+
+	// nobody else can speak under the host's name
+	write(t, bob, Message{Type: TypeMessage, Name: "DJ", Text: "it's me"})
+	if m := next(t, bob, TypeError); !strings.Contains(m.Text, "host's name") {
+		t.Errorf("got %+v", m)
+	}
+	write(t, bob, Message{Type: TypeMessage, Name: "bob", Text: "hi"})
+	if m := next(t, bob, TypeMessage); m.Host {
+		t.Errorf("marked as host %+v", m)
+	}
+
+	// once the host leaves, the name is free
+	host.Close()
+	next(t, bob, TypeLeave)
+	write(t, bob, Message{Type: TypeMessage, Name: "dj", Text: "it's me now"})
+	if m := next(t, bob, TypeMessage); m.Host || m.Name != "dj" {
+		t.Errorf("got %+v", m)
+	}
+}
@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Time between presence snapshots, which are only sent when they changed
+const presencePeriod = 5 * time.Second
+
+// Presence is who is in a room
+type Presence struct {
+	Room string `json:"room"`
+	// Names of everyone who picked a nickname, sorted
+	Names []string `json:"names"`
+	// Chatters counts chat connections, with a nickname or not
+	Chatters int `json:"chatters"`
+	// Listeners counts people listening to the audio
+	Listeners int `json:"listeners"`
+}
+
+func (p Presence) equal(q Presence) bool {
+	return p.Chatters == q.Chatters && p.Listeners == q.Listeners && slices.Equal(p.Names, q.Names)
+}
+
+// presenceQuery asks the hub for the presence of a room
+type presenceQuery struct {
+	room   string
+	result chan Presence
+}
+
+// departure is a nickname that left a room
+type departure struct {
+	room string
+	name string
+}
+
+// Presence returns who is in room right now. Nobody is once the hub has
+// shut down.
+func (h *Hub) Presence(room string) Presence {
+	q := presenceQuery{room, make(chan Presence, 1)}
+	if !submit(h, h.query, q) {
+		return Presence{Room: room, Names: []string{}}
+	}
+	return <-q.result
+}
+
+// presence takes a snapshot of who is in a room
+func (h *Hub) presence(room string) (p Presence) {
+	p = Presence{Room: room, Names: []string{}}
+	seen := make(map[string]bool)
+	for c := range h.rooms[room] {
+		if c.via != "" {
+			continue
+		}
+		p.Chatters++
+		if c.name != "" && !seen[strings.ToLower(c.name)] {
+			seen[strings.ToLower(c.name)] = true
+			p.Names = append(p.Names, c.name)
+		}
+	}
+	sort.Slice(p.Names, func(i, j int) bool {
+		return strings.ToLower(p.Names[i]) < strings.ToLower(p.Names[j])
+	})
+	if h.Listeners != nil {
+		p.Listeners = h.Listeners(room)
+	}
+	return
+}
+
+// people counts the connections in a room that are not relays
+func (h *Hub) people(room string) (n int) {
+	for c := range h.rooms[room] {
+		if c.via == "" {
+			n++
+		}
+	}
+	return
+}
+
+// named counts the connections in a room using name
+func (h *Hub) named(room, name string) (n int) {
+	for c := range h.rooms[room] {
+		if strings.EqualFold(c.name, name) {
+			n++
+		}
+	}
+	return
+}
+
+// rename changes the nickname of a connection, announcing who joined and
+// who left the room
+func (h *Hub) rename(s subscription, name string) {
+	c := s.conn
+	old := c.name
+	if strings.EqualFold(old, name) {
+		c.name = name
+		return
+	}
+	c.name = name
+	if old != "" && h.named(s.room, old) == 0 {
+		h.send(s.room, Message{Type: TypeLeave, Name: old})
+	}
+	if name != "" && h.named(s.room, name) == 1 {
+		h.send(s.room, Message{Type: TypeJoin, Name: name})
+	}
+}
+
+// announceDepartures tells rooms about nicknames whose last connection was
+// dropped
+func (h *Hub) announceDepartures() {
+	departures := h.departed
+	h.departed = nil
+	for _, d := range departures {
+		if h.named(d.room, d.name) == 0 {
+			h.send(d.room, Message{Type: TypeLeave, Name: d.name})
+		}
+	}
+}
+
+// sendPresence sends a snapshot to every room whose presence changed
+func (h *Hub) sendPresence() {
+	for room := range h.sent {
+		if _, ok := h.rooms[room]; !ok {
+			delete(h.sent, room)
+		}
+	}
+	for room := range h.rooms {
+		p := h.presence(room)
+		if last, ok := h.sent[room]; ok && last.equal(p) {
+			continue
+		}
+		h.sent[room] = p
+		h.send(room, Message{Type: TypePresence, Presence: &p})
+	}
+}
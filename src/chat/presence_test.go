@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPresence(t *testing.T) {
+	h, srv, _ := startHub(t, func(h *Hub) {
+		h.Listeners = func(room string) int { return len(room) }
+	})
+	ann := dial(t, srv, "room=show&name=ann")
+	if p := next(t, ann, TypePresence).Presence; p.Chatters != 1 || p.Listeners != 4 || strings.Join(p.Names, ",") != "ann" {
+		t.Errorf("presence %+v", p)
+	}
+
+	// a second connection under the same name joins nobody new
+	dial(t, srv, "room=show&name=ann")
+	bob := dial(t, srv, "room=show&name=bob")
+	if m := next(t, ann, TypeJoin); m.Name != "bob" {
+		t.Errorf("joined %+v", m)
+	}
+	anonymous := dial(t, srv, "room=show")
+	next(t, anonymous, TypePresence)
+	if p := h.Presence("show"); p.Chatters != 4 || strings.Join(p.Names, ",") != "ann,bob" {
+		t.Errorf("presence %+v", p)
+	}
+
+	// writing under a new name is a rename
+	write(t, bob, Message{Type: TypeMessage, Name: "robert", Text: "hi"})
+	if m := next(t, ann, TypeLeave); m.Name != "bob" {
+		t.Errorf("left %+v", m)
+	}
+	if m := next(t, ann, TypeJoin); m.Name != "robert" {
+		t.Errorf("joined %+v", m)
+	}
+	bob.Close()
+	if m := next(t, ann, TypeLeave); m.Name != "robert" {
+		t.Errorf("left %+v", m)
+	}
+
+	// anyone can ask
+	write(t, anonymous, Message{Type: TypePresence})
+	if p := next(t, anonymous, TypePresence).Presence; p.Chatters != 3 || strings.Join(p.Names, ",") != "ann" {
+		t.Errorf("presence %+v", p)
+	}
+}
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Relay is a subscriber that carries a room's messages to and from
+// somewhere outside of the chat, like an IRC channel. It is a connection to
+// the room like any other, except that it speaks for many people and does
+// not count as one.
+type Relay struct {
+	hub      *Hub
+	sub      subscription
+	messages chan Message
+}
+
+// Relay subscribes to room on behalf of via, which names where the relayed
+// messages come from. It returns nil if the hub has shut down.
+func (h *Hub) Relay(room, via string) *Relay {
+	c := &connection{
+		hub:  h,
+		send: make(chan []byte, sendBufferSize),
+		via:  via,
+	}
+	r := &Relay{
+		hub:      h,
+		sub:      subscription{c, room},
+		messages: make(chan Message, sendBufferSize),
+	}
+	if !submit(h, h.register, r.sub) {
+		return nil
+	}
+	go r.pump()
+	return r
+}
+
+// pump decodes what the hub sends, leaving out what the relay sent itself
+func (r *Relay) pump() {
+	defer close(r.messages)
+	for b := range r.sub.conn.send {
+		var m Message
+		if err := json.Unmarshal(b, &m); err != nil {
+			continue
+		}
+		if (m.Type == TypeMessage && m.Via != r.sub.conn.via) || m.Type == TypeSystem {
+			r.messages <- m
+		}
+	}
+}
+
+// Messages are the chat messages and notices sent to the room by everyone
+// else. It is closed when the hub drops the relay.
+func (r *Relay) Messages() <-chan Message {
+	return r.messages
+}
+
+// Send posts text to the room as name. Both are cleaned up like the
+// messages of anyone in the chat.
+func (r *Relay) Send(name, text string) (err error) {
+	name, text = sanitize(name), sanitize(text)
+	if name == "" || text == "" {
+		return fmt.Errorf("empty message")
+	}
+	name = truncate(name, maxNameLength)
+	text = truncate(text, maxTextLength)
+	if !submit(r.hub, r.hub.broadcast, message{Message{Type: TypeMessage, Name: name, Text: text}, r.sub}) {
+		err = fmt.Errorf("chat is shut down")
+	}
+	return
+}
+
+// Close unsubscribes the relay from its room
+func (r *Relay) Close() {
+	submit(r.hub, r.hub.unregister, r.sub)
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
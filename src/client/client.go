@@ -2,28 +2,80 @@ package client
 
 import (
 	"fmt"
-	"net/http"
-	"net/url"
+	"io"
 	"os"
-	"os/exec"
 	"os/signal"
-	"runtime"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/chzyer/readline"
 	"github.com/manifoldco/promptui"
 	"github.com/schollz/streammyaudio/src/clearscreen"
 	"github.com/schollz/streammyaudio/src/ffmpeg"
 )
 
 type Client struct {
-	Name       string
-	Advertise  string
-	Archive    string
+	Name      string
+	Advertise string
+	Archive   string
+	// DeviceName is the name of the device being streamed
 	DeviceName string
-	Device     string
-	Server     string
-	Quality    int
+	// Device picks the device by index or name instead of asking
+	Device string
+	// Gain is the gain of the device or Source in dB
+	Gain float64
+	// Mix are more devices mixed into the stream
+	Mix     []Input
+	Server  string
+	Quality int
+	// Codec is CodecMP3 or CodecMP3CBR
+	Codec string
+	// Preset is the processing chain, PresetRaw, PresetVoice or PresetMusic
+	Preset string
+	// Highpass, Gate, Compress, Loudness and Limit replace the preset's
+	// steps, see Processing. 0 leaves a step out, nil keeps the preset's.
+	Highpass *float64
+	Gate     *float64
+	Compress *float64
+	Loudness *float64
+	Limit    *float64
+	// Config is the profiles file, see ProfilesFile
+	Config string
+	// SaveProfile saves the choices as a profile with this name
+	SaveProfile string
+	// Record is where the stream is also saved, a template with {name} and
+	// {time} in it. Lossless is the same for a WAV or FLAC copy.
+	Record   string
+	Lossless string
+	// Targets are more servers to cast the same stream to
+	Targets []Target
+	// Silence is how long the input may be silent before SilenceAction is
+	// taken, 0 never does anything
+	Silence time.Duration
+	// SilenceAction is SilenceWarn, SilenceChat or SilenceStop
+	SilenceAction string
+	// Source is a file, .m3u playlist or URL to stream instead of a device
+	Source string
+	// Shuffle and Loop change how the tracks of a playlist are played
+	Shuffle bool
+	Loop    bool
+
+	// interactive is set when questions can be asked on stdin
+	interactive bool
+
+	// where the stream goes and what it is playing, for now playing
+	mutex sync.Mutex
+	dests []*destination
+	title string
+
+	status status
+}
+
+// isTerminal reports whether stdin is a terminal that can answer prompts
+func isTerminal() bool {
+	return readline.IsTerminal(int(os.Stdin.Fd()))
 }
 
 func (c *Client) Run() (err error) {
@@ -32,7 +84,10 @@ func (c *Client) Run() (err error) {
 		ffmpeg.Clean()
 	}()
 
-	clearscreen.ClearScreen()
+	c.interactive = isTerminal()
+	if c.interactive {
+		clearscreen.ClearScreen()
+	}
 	fmt.Println("\n" + `     _______..___________..______       _______      ___      .___  ___.     
     /       ||           ||   _  \     |   ____|    /   \     |   \/   |     
    |   (----` + "`" + `` + "`" + `---|  |----` + "`" + `|  |_)  |    |  |__      /  ^  \    |  \  /  |     
@@ -49,6 +104,11 @@ func (c *Client) Run() (err error) {
                                                                             `)
 	err = c.cast()
 	if err != nil {
+		if !c.interactive {
+			// scripts need to know it failed
+			fmt.Printf("        %s\n", err)
+			return
+		}
 		fmt.Println("        no stream initiated, goodbye.")
 		time.Sleep(1 * time.Second)
 		err = nil
@@ -56,202 +116,209 @@ func (c *Client) Run() (err error) {
 	return
 }
 
-func (c *Client) windowsSelectAudioDevice() (cmd *exec.Cmd, err error) {
-	cmd = exec.Command(ffmpeg.Binary(), "-list_devices", "true", "-f", "dshow", "-i", "dummy")
-	output, err := cmd.CombinedOutput()
-	// if err != nil {
-	// 	panic(err)
-	// }
-	inputDevices := []string{}
-	inputDeviceNames := []string{}
-
-	for _, line := range strings.Split(string(output), "\n") {
-		if strings.HasPrefix(line, "[dshow") {
-			parts := strings.Fields(line)
-			if len(parts) < 2 {
-				continue
-			}
-			foo := strings.Split(line, ` "`)
-			if len(foo) < 2 {
-				continue
-			}
-			name := foo[1]
-			name = name[:len(name)-2]
-			if strings.HasPrefix(foo[1], "@device") {
-				inputDeviceNames = append(inputDeviceNames, strings.TrimSpace(name))
-			} else {
-				inputDevices = append(inputDevices, strings.TrimSpace(name))
-			}
-		}
-	}
-	if len(inputDevices) != len(inputDeviceNames) {
-		err = fmt.Errorf("devices names do not match %d!=%d", len(inputDevices), len(inputDeviceNames))
-	}
-
-	prompt := promptui.Select{
-		Label: "Select input device",
-		Items: inputDevices,
-		Size:  len(inputDevices),
-	}
-
-	var i int
-	i, c.DeviceName, err = prompt.Run()
-	result := inputDeviceNames[i]
-
+func (c *Client) cast() (err error) {
+	err = c.getStreamInfo()
 	if err != nil {
 		return
 	}
-	cmd = exec.Command(ffmpeg.Binary(), "-f", "dshow", "-i", "audio="+result, "-f", "mp3", "-q:a", fmt.Sprint(c.Quality), "-")
-	return
-}
 
-func (c *Client) linuxSelectAudioDevice() (cmd *exec.Cmd, err error) {
-	cmd = exec.Command("cat", "/proc/asound/cards")
-	output, err := cmd.CombinedOutput()
+	silenceAction, err := c.silenceAction()
 	if err != nil {
 		return
 	}
-
-	inputDeviceNames := []string{}
-	for _, line := range strings.Split(string(output), "\n") {
-		if strings.Contains(line, "[") {
-			inputDeviceNames = append(inputDeviceNames, strings.TrimSpace(line))
+	dests := c.destinations()
+	c.mutex.Lock()
+	c.dests = dests
+	c.mutex.Unlock()
+
+	started := time.Now()
+	lossless := ""
+	if c.Lossless != "" {
+		lossless = recordingName(c.Lossless, c.Name, started)
+	}
+	var inputs []input
+	var tracks []track
+	if c.Source != "" {
+		if tracks, err = playlist(c.Source); err != nil {
+			return
 		}
+		inputs = append(inputs, input{append(pcmFormat, "-i", "-"), c.Gain})
 	}
-
-	prompt := promptui.Select{
-		Label: "Select input device",
-		Items: inputDeviceNames,
-		Size:  len(inputDeviceNames),
+	var devices []device
+	if c.Source == "" || len(c.Mix) > 0 {
+		if devices, err = audioDevices(); err != nil {
+			return
+		}
 	}
-
-	var i int
-	i, c.DeviceName, err = prompt.Run()
-
+	// whoever picks the device here is asked about mixing too
+	ask := c.interactive && c.Source == "" && c.Device == "" && len(c.Mix) == 0
+	if c.Source == "" {
+		var d device
+		if d, err = c.selectAudioDevice(devices); err != nil {
+			return
+		}
+		inputs = append(inputs, input{d.args(), c.Gain})
+	}
+	mix, err := c.selectMix(devices, ask)
 	if err != nil {
 		return
 	}
-	cmd = exec.Command("ffmpeg", "-f", "alsa", "-i", fmt.Sprintf("hw:%d", i), "-f", "mp3", "-q:a", fmt.Sprint(c.Quality), "-")
-	return
-}
-
-func (c *Client) darwinSelectAudioDevice() (cmd *exec.Cmd, err error) {
-	cmd = exec.Command(ffmpeg.Binary(), "-f", "avfoundation", "-list_devices", "true", "-i", "dummy")
-	output, _ := cmd.CombinedOutput()
-	inputDeviceNames := []string{}
-
-	haveAudioDevices := false
-	for _, line := range strings.Split(string(output), "\n") {
-		if strings.Contains(line, "audio devices") {
-			haveAudioDevices = true
-			continue
+	cmd, err := c.command(append(inputs, mix...), lossless)
+	if err != nil {
+		return
+	}
+	if lossless != "" {
+		if err = os.MkdirAll(filepath.Dir(lossless), 0o755); err != nil {
+			return
 		}
-		if strings.Contains(line, "AVFoundation") && haveAudioDevices {
-			parts := strings.Split(line, "]")
-			if len(parts) < 2 {
-				continue
-			}
-			name := parts[len(parts)-1]
-			inputDeviceNames = append(inputDeviceNames, strings.TrimSpace(name))
+		c.printf("recording a lossless copy to %s\n", lossless)
+	}
+	var output io.Writer = io.Discard
+	if c.Record != "" {
+		var f *os.File
+		if f, err = createRecording(recordingName(c.Record, c.Name, started)); err != nil {
+			return
 		}
+		defer f.Close()
+		output = &recorder{f: f, printf: c.printf}
+		c.printf("recording the stream to %s\n", f.Name())
 	}
-
-	prompt := promptui.Select{
-		Label: "Select input device",
-		Items: inputDeviceNames,
-		Size:  len(inputDeviceNames),
+	if c.SaveProfile != "" {
+		var filename string
+		filename, err = SaveProfile(c.Config, c.SaveProfile, c.profile())
+		if err != nil {
+			return
+		}
+		c.printf("saved profile '%s' in %s\n", c.SaveProfile, filename)
 	}
 
-	var i int
-	i, c.DeviceName, err = prompt.Run()
+	sp, err := newSpool()
 	if err != nil {
 		return
 	}
-	cmd = exec.Command(ffmpeg.Binary(), "-f", "avfoundation", "-i", fmt.Sprintf(":%d", i), "-f", "mp3", "-q:a", fmt.Sprint(c.Quality), "-")
-	return
-}
+	defer sp.close()
 
-func (c *Client) cast() (err error) {
-	err = c.getStreamInfo()
+	quit := make(chan struct{})
+	var quitOnce sync.Once
+	cc := make(chan os.Signal, 1)
+	signal.Notify(cc, os.Interrupt)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return
 	}
-
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd, err = c.windowsSelectAudioDevice()
-	case "darwin":
-		cmd, err = c.darwinSelectAudioDevice()
-	case "linux":
-		cmd, err = c.linuxSelectAudioDevice()
-	default:
-		fmt.Printf("%s.\n", runtime.GOOS)
-	}
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return
 	}
-
-	canceled := false
-	cc := make(chan os.Signal, 1)
-	signal.Notify(cc, os.Interrupt)
-	go func() {
-		for range cc {
-			canceled = true
-			// sig is a ^C, handle it
-			cmd.Process.Kill()
+	var stdin io.WriteCloser
+	if tracks != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return
 		}
-	}()
-	// output, err := cmd.CombinedOutput()
-	// fmt.Println(string(output))
-	// fmt.Println(err)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return
 	}
 	err = cmd.Start()
 	if err != nil {
 		return
 	}
-	tr := http.DefaultTransport
-	client := &http.Client{
-		Transport: tr,
-		Timeout:   0,
-	}
-	r := stdout
-	req := &http.Request{
-		Method: "POST",
-		URL: &url.URL{
-			Scheme:   strings.Split(c.Server, "://")[0],
-			Host:     strings.Split(c.Server, "://")[1],
-			Path:     "/" + c.Name + ".mp3",
-			RawQuery: "stream=true&advertise=" + c.Advertise + "&archive=" + c.Archive,
-		},
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		ContentLength: -1,
-		Body:          r,
+	end := func() {
+		quitOnce.Do(func() { close(quit) })
+		stop(cmd)
 	}
-
+	// a ^C before ffmpeg started waits in cc until here
 	go func() {
-		_, err = client.Do(req)
-		if err != nil && !canceled {
-			fmt.Println("problem connecting: %s", err.Error())
-			cmd.Process.Kill()
+		for range cc {
+			// sig is a ^C, handle it
+			end()
 		}
 	}()
+	if tracks != nil {
+		go c.play(tracks, stdin, quit)
+	}
+	go c.readLevels(stderr)
+	// ffmpeg keeps recording into the spool while the connection is down
+	go sp.fill(io.TeeReader(stdout, output))
 
-	fmt.Printf("\n\nnow streaming at\n")
-	fmt.Printf("\n%s/%s\n\n", c.Server, c.Name)
-	fmt.Printf("press Ctl+C to quit\n")
-
+	done := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		c.watchStatus(done)
+		close(watched)
+	}()
+	go c.watchSilence(silenceAction, done, end)
+	// each destination reconnects on its own, the stream only fails if it
+	// failed everywhere
+	errs := make([]error, len(dests))
+	var wg sync.WaitGroup
+	for i, d := range dests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.send(d, sp, quit)
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-watched
+	err = errs[0]
+	for _, errSend := range errs {
+		if errSend == nil {
+			err = nil
+		}
+	}
+	stop(cmd)
 	cmd.Wait()
+	sp.wait()
+	if err != nil {
+		return
+	}
 	fmt.Println("goodbye.")
 	time.Sleep(1 * time.Second)
 	return
 }
 
+// missing lists the flags that still need to be set to stream without
+// asking anything
+func (c *Client) missing() (flags []string) {
+	if strings.TrimSpace(c.Name) == "" {
+		flags = append(flags, "--cast-name")
+	}
+	if c.Quality < 0 || c.Quality > 9 {
+		flags = append(flags, "--cast-quality (0 best to 9 worst)")
+	}
+	if c.Advertise == "" {
+		flags = append(flags, "--cast-advertise (yes/no)")
+	}
+	if c.Archive == "" {
+		flags = append(flags, "--cast-archive (yes/no)")
+	}
+	if c.Device == "" && c.Source == "" {
+		flags = append(flags, "--cast-device (a name or index from --cast-devices)")
+	}
+	return
+}
+
+// isYes reads the answer to a yes/no question
+func isYes(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.Contains(s, "yes") || s == "y" || s == "true" || s == "1"
+}
+
+// yesNo is an answer as it is written in profiles
+func yesNo(s string) string {
+	if isYes(s) {
+		return "yes"
+	}
+	return "no"
+}
+
 func (c *Client) getStreamInfo() (err error) {
+	if !c.interactive {
+		if flags := c.missing(); len(flags) > 0 {
+			err = fmt.Errorf("stdin is not a terminal, so nothing can be asked. set %s", strings.Join(flags, ", "))
+			return
+		}
+	}
+
 	validate := func(input string) error {
 		if strings.TrimSpace(input) == "" {
 			return fmt.Errorf("name cannot be empty")
@@ -301,7 +368,7 @@ func (c *Client) getStreamInfo() (err error) {
 		}
 
 	}
-	if strings.Contains(c.Advertise, "yes") {
+	if isYes(c.Advertise) {
 		c.Advertise = "true"
 	} else {
 		c.Advertise = "false"
@@ -317,7 +384,7 @@ func (c *Client) getStreamInfo() (err error) {
 			return
 		}
 	}
-	if strings.Contains(c.Archive, "yes") {
+	if isYes(c.Archive) {
 		c.Archive = "true"
 	} else {
 		c.Archive = "false"
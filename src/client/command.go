@@ -0,0 +1,99 @@
+package client
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/schollz/streammyaudio/src/ffmpeg"
+)
+
+// Codecs the server can stream. Listeners and archives expect mp3, so the
+// choice is between a variable and a constant bitrate.
+const (
+	// CodecMP3 is variable bitrate mp3, the smallest for a given quality
+	CodecMP3 = "mp3"
+	// CodecMP3CBR is constant bitrate mp3, steadier over poor connections
+	CodecMP3CBR = "mp3-cbr"
+)
+
+// bitrates of the constant bitrate codec for each quality, best first
+var bitrates = []int{320, 256, 224, 192, 160, 128, 112, 96, 80, 64}
+
+// codecArgs are the ffmpeg arguments that encode the stream
+func (c *Client) codecArgs() (args []string, err error) {
+	if c.Quality < 0 || c.Quality > 9 {
+		err = fmt.Errorf("quality must be 0 (best) to 9 (worst), not %d", c.Quality)
+		return
+	}
+	switch strings.ToLower(c.Codec) {
+	case "", CodecMP3:
+		args = []string{"-f", "mp3", "-q:a", fmt.Sprint(c.Quality)}
+	case CodecMP3CBR:
+		args = []string{"-f", "mp3", "-b:a", fmt.Sprintf("%dk", bitrates[c.Quality])}
+	default:
+		err = fmt.Errorf("unknown codec '%s', use %s or %s", c.Codec, CodecMP3, CodecMP3CBR)
+	}
+	return
+}
+
+// pcmFormat is the raw audio tracks are decoded to for the encoder
+var pcmFormat = []string{"-f", "s16le", "-ar", "44100", "-ac", "2"}
+
+// input is what the stream is made of: the ffmpeg arguments of a device or
+// of raw audio on stdin, and its gain in dB
+type input struct {
+	args []string
+	gain float64
+}
+
+// command is the ffmpeg process that encodes the inputs, mixed together if
+// there are more and processed as the preset says, and writes the stream to
+// its stdout. If lossless is set, the audio is also recorded there as WAV or
+// FLAC, without the processing. The levels of the audio are printed on
+// stderr.
+func (c *Client) command(inputs []input, lossless string) (cmd *exec.Cmd, err error) {
+	codec, err := c.codecArgs()
+	if err != nil {
+		return
+	}
+	p, err := c.processing()
+	if err != nil {
+		return
+	}
+	process := p.filters()
+	var output []string
+	if lossless != "" {
+		if output, err = losslessArgs(lossless); err != nil {
+			return
+		}
+	}
+	args := []string{"-hide_banner", "-nostats"}
+	gains := []float64{}
+	for _, in := range inputs {
+		if len(inputs) > 1 {
+			// live inputs read at the same time need room to wait for each other
+			args = append(args, "-thread_queue_size", "1024")
+		}
+		args = append(args, in.args...)
+		gains = append(gains, in.gain)
+	}
+	if len(inputs) == 1 && inputs[0].gain == 0 {
+		if process == "" {
+			process = "anull"
+		}
+		// the levels are measured on a copy before processing, so the
+		// meter shows what the device picks up
+		filters := "asplit=2[main][meter];[meter]" + meterChain + ";[main]" + process
+		args = append(append(args, "-af", filters), codec...)
+		args = append(append(args, "-"), output...)
+	} else {
+		args = append(args, "-filter_complex", mixGraph(gains, process, lossless != ""), "-map", "[stream]")
+		args = append(append(args, codec...), "-")
+		if lossless != "" {
+			args = append(append(args, "-map", "[lossless]"), output...)
+		}
+	}
+	cmd = exec.Command(ffmpeg.Binary(), args...)
+	return
+}
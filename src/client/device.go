@@ -0,0 +1,182 @@
+package client
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/schollz/streammyaudio/src/ffmpeg"
+)
+
+// device is an audio input ffmpeg can record from
+type device struct {
+	// Name is shown to the user and matched by --cast-device
+	Name string
+	// Format is the ffmpeg input format, like pulse, alsa or dshow
+	Format string
+	// Input is the ffmpeg input, like hw:0
+	Input string
+}
+
+// args are the ffmpeg arguments that read from the device
+func (d device) args() []string {
+	return []string{"-f", d.Format, "-i", d.Input}
+}
+
+// audioDevices lists the inputs of this computer
+func audioDevices() (devices []device, err error) {
+	switch runtime.GOOS {
+	case "windows":
+		output, _ := exec.Command(ffmpeg.Binary(), "-list_devices", "true", "-f", "dshow", "-i", "dummy").CombinedOutput()
+		devices, err = parseDshow(string(output))
+	case "darwin":
+		output, _ := exec.Command(ffmpeg.Binary(), "-f", "avfoundation", "-list_devices", "true", "-i", "dummy").CombinedOutput()
+		devices = parseAVFoundation(string(output))
+	case "linux":
+		devices, err = linuxAudioDevices()
+	default:
+		err = fmt.Errorf("recording audio is not supported on %s", runtime.GOOS)
+		return
+	}
+	if err == nil && len(devices) == 0 {
+		err = fmt.Errorf("no audio devices found")
+	}
+	return
+}
+
+// parseDshow reads the devices from "ffmpeg -list_devices true -f dshow".
+// Each device is followed by its alternative name, which is what ffmpeg
+// opens.
+func parseDshow(output string) (devices []device, err error) {
+	names := []string{}
+	altNames := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "[dshow") {
+			continue
+		}
+		_, name, ok := strings.Cut(line, ` "`)
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, `"`)
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "@device") {
+			altNames = append(altNames, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+	if len(names) != len(altNames) {
+		err = fmt.Errorf("devices names do not match %d!=%d", len(names), len(altNames))
+		return
+	}
+	for i, name := range names {
+		devices = append(devices, device{Name: name, Format: "dshow", Input: "audio=" + altNames[i]})
+	}
+	return
+}
+
+// parseAVFoundation reads the audio devices from
+// "ffmpeg -f avfoundation -list_devices true"
+func parseAVFoundation(output string) (devices []device) {
+	haveAudioDevices := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, "audio devices") {
+			haveAudioDevices = true
+			continue
+		}
+		if strings.Contains(line, "AVFoundation") && haveAudioDevices {
+			parts := strings.Split(line, "]")
+			if len(parts) < 2 {
+				continue
+			}
+			devices = append(devices, device{
+				Name:   strings.TrimSpace(parts[len(parts)-1]),
+				Format: "avfoundation",
+				Input:  fmt.Sprintf(":%d", len(devices)),
+			})
+		}
+	}
+	return
+}
+
+// findDevice picks a device by its index in the list or by its name. A name
+// may be any part of the device's name, as long as only one device has it.
+func findDevice(devices []device, want string) (d device, err error) {
+	want = strings.TrimSpace(want)
+	if i, errAtoi := strconv.Atoi(want); errAtoi == nil {
+		if i < 0 || i >= len(devices) {
+			err = fmt.Errorf("no device %d, there are %d devices", i, len(devices))
+			return
+		}
+		d = devices[i]
+		return
+	}
+	matches := []device{}
+	for _, dev := range devices {
+		if strings.EqualFold(dev.Name, want) {
+			d = dev
+			return
+		}
+		if strings.Contains(strings.ToLower(dev.Name), strings.ToLower(want)) {
+			matches = append(matches, dev)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		err = fmt.Errorf("no device named '%s'", want)
+	case 1:
+		d = matches[0]
+	default:
+		names := []string{}
+		for _, dev := range matches {
+			names = append(names, "'"+dev.Name+"'")
+		}
+		err = fmt.Errorf("'%s' could be any of %s", want, strings.Join(names, ", "))
+	}
+	return
+}
+
+// selectAudioDevice picks the device set with --cast-device, or asks for one
+func (c *Client) selectAudioDevice(devices []device) (d device, err error) {
+	if c.Device != "" {
+		d, err = findDevice(devices, c.Device)
+	} else {
+		names := []string{}
+		for _, dev := range devices {
+			names = append(names, dev.Name)
+		}
+		prompt := promptui.Select{
+			Label: "Select input device",
+			Items: names,
+			Size:  len(names),
+		}
+		var i int
+		i, _, err = prompt.Run()
+		if err == nil {
+			d = devices[i]
+		}
+	}
+	if err != nil {
+		return
+	}
+	c.DeviceName = d.Name
+	return
+}
+
+// ListDevices prints the audio devices with the index --cast-device takes
+func (c *Client) ListDevices() (err error) {
+	defer ffmpeg.Clean()
+	devices, err := audioDevices()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i, d := range devices {
+		fmt.Printf("%2d  %s\n", i, d.Name)
+	}
+	return
+}
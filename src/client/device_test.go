@@ -0,0 +1,130 @@
+package client
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const asoundCards = ` 0 [PCH            ]: HDA-Intel - HDA Intel PCH
+                      HDA Intel PCH at 0xf7f10000 irq 32
+ 2 [Device         ]: USB-Audio - USB Audio Device
+                      C-Media Electronics Inc. USB Audio Device at usb-0000:00:14.0-2, full speed
+`
+
+const asoundPCM = `00-00: ALC892 Analog : ALC892 Analog : playback 1 : capture 1
+00-03: HDMI 0 : HDMI 0 : playback 1
+02-00: USB Audio : USB Audio : playback 1 : capture 1
+02-01: USB Audio #1 : USB Audio #1 : capture 1
+`
+
+const pactlSources = `Source #56
+	State: SUSPENDED
+	Name: alsa_output.pci-0000_00_1f.3.analog-stereo.monitor
+	Description: Monitor of Built-in Audio Analog Stereo
+	Driver: PipeWire
+	Properties:
+		device.description = "Built-in Audio Analog Stereo"
+		node.name = "alsa_output.pci-0000_00_1f.3.analog-stereo"
+Source #57
+	State: RUNNING
+	Name: alsa_input.usb-C-Media_USB_Audio_Device-00.mono-fallback
+	Description: USB Audio Device Mono
+	Driver: PipeWire
+`
+
+const dshowDevices = `[dshow @ 000001] DirectShow audio devices
+[dshow @ 000001]  "Microphone (Realtek Audio)"
+[dshow @ 000001]     Alternative name "@device_cm_{33D9A762}\wave_{A1B2}"
+[dshow @ 000001]  "Line In (USB Audio)"
+[dshow @ 000001]     Alternative name "@device_cm_{33D9A762}\wave_{C3D4}"
+`
+
+func TestParseDevices(t *testing.T) {
+	alsa := parseALSA(asoundCards)
+	if len(alsa) != 2 || alsa[1].Input != "hw:2" || alsa[1].Format != "alsa" ||
+		!strings.Contains(alsa[1].Name, "USB Audio Device") {
+		t.Errorf("alsa %+v", alsa)
+	}
+
+	pcm := parseALSAPCM(asoundPCM, asoundCards)
+	if len(pcm) != 3 || pcm[0].Input != "hw:0,0" || pcm[2].Input != "hw:2,1" ||
+		pcm[2].Name != "USB Audio Device: USB Audio #1 (hw:2,1)" {
+		t.Errorf("alsa pcm %+v", pcm)
+	}
+
+	pulse := parsePactl(pactlSources)
+	if len(pulse) != 2 || pulse[0].Format != "pulse" ||
+		pulse[0].Input != "alsa_output.pci-0000_00_1f.3.analog-stereo.monitor" ||
+		pulse[0].Name != "Monitor of Built-in Audio Analog Stereo (pulse)" ||
+		pulse[1].Name != "USB Audio Device Mono (pulse)" {
+		t.Errorf("pulse %+v", pulse)
+	}
+	if args := pulse[1].args(); strings.Join(args, " ") != "-f pulse -i alsa_input.usb-C-Media_USB_Audio_Device-00.mono-fallback" {
+		t.Errorf("args %v", args)
+	}
+
+	dshow, err := parseDshow(dshowDevices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dshow) != 2 || dshow[0].Name != "Microphone (Realtek Audio)" ||
+		dshow[0].Input != `audio=@device_cm_{33D9A762}\wave_{A1B2}` {
+		t.Errorf("dshow %+v", dshow)
+	}
+}
+
+func TestFindDevice(t *testing.T) {
+	devices := []device{{Name: "Built-in Microphone"}, {Name: "USB Microphone"}, {Name: "USB"}}
+	for want, name := range map[string]string{
+		"1":             "USB Microphone",
+		"built-in":      "Built-in Microphone",
+		"usb":           "USB",
+		"usb micro":     "USB Microphone",
+		"Built-in Micr": "Built-in Microphone",
+	} {
+		d, err := findDevice(devices, want)
+		if err != nil || d.Name != name {
+			t.Errorf("%q: got %q, %v", want, d.Name, err)
+		}
+	}
+	for _, want := range []string{"3", "microphone", "line in"} {
+		if d, err := findDevice(devices, want); err == nil {
+			t.Errorf("%q: got %q", want, d.Name)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "profiles.json")
+	if _, err := LoadProfile(config, "show"); err == nil {
+		t.Error("loaded a profile that was never saved")
+	}
+
+	off := 0.0
+	c := &Client{Name: "show", Server: "http://localhost:9222", DeviceName: "USB Microphone",
+		Codec: CodecMP3CBR, Quality: 4, Advertise: "true", Archive: "false", Preset: PresetVoice, Gate: &off, Loudness: &off}
+	if _, err := SaveProfile(config, "show", c.profile()); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadProfile(config, "show")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &Client{Quality: -1}
+	loaded.Apply(p)
+	if len(loaded.missing()) != 0 {
+		t.Errorf("profile is missing %v", loaded.missing())
+	}
+	if loaded.Device != "USB Microphone" || loaded.Quality != 4 || !isYes(loaded.Advertise) || isYes(loaded.Archive) {
+		t.Errorf("loaded %+v", loaded)
+	}
+	if args, err := loaded.codecArgs(); err != nil || strings.Join(args, " ") != "-f mp3 -b:a 160k" {
+		t.Errorf("codec %v, %v", args, err)
+	}
+	// a step left out of the preset stays out
+	if loaded.Gate == nil || *loaded.Gate != 0 || loaded.Loudness == nil || *loaded.Loudness != 0 || loaded.Highpass != nil {
+		t.Errorf("loaded gate %v, loudness %v, highpass %v", loaded.Gate, loaded.Loudness, loaded.Highpass)
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	log "github.com/schollz/logger"
+)
+
+// linuxAudioDevices lists the PulseAudio or PipeWire sources, monitors
+// included, followed by the ALSA capture devices
+func linuxAudioDevices() (devices []device, err error) {
+	cmd := exec.Command("pactl", "list", "sources")
+	// the labels are translated otherwise
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	if output, errPactl := cmd.Output(); errPactl == nil {
+		devices = append(devices, parsePactl(string(output))...)
+	} else {
+		log.Debugf("pactl: %s", errPactl)
+	}
+
+	cards, err := os.ReadFile("/proc/asound/cards")
+	if err != nil {
+		if len(devices) > 0 {
+			err = nil
+		}
+		return
+	}
+	pcm, errPCM := os.ReadFile("/proc/asound/pcm")
+	if errPCM != nil {
+		log.Debugf("alsa: %s", errPCM)
+		devices = append(devices, parseALSA(string(cards))...)
+		return
+	}
+	devices = append(devices, parseALSAPCM(string(pcm), string(cards))...)
+	return
+}
+
+// parsePactl reads the sources from "pactl list sources", which look like
+//
+//	Source #0
+//		Name: alsa_output.pci-0000_00_1f.3.analog-stereo.monitor
+//		Description: Monitor of Built-in Audio Analog Stereo
+func parsePactl(output string) (devices []device) {
+	var d *device
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Source #") {
+			devices = append(devices, device{Format: "pulse"})
+			d = &devices[len(devices)-1]
+		} else if d == nil {
+			continue
+		} else if name, ok := strings.CutPrefix(line, "Name: "); ok {
+			d.Input = name
+		} else if description, ok := strings.CutPrefix(line, "Description: "); ok {
+			d.Name = description
+		}
+	}
+	found := devices[:0]
+	for _, d := range devices {
+		if d.Input == "" {
+			continue
+		}
+		if d.Name == "" {
+			d.Name = d.Input
+		}
+		d.Name += " (pulse)"
+		found = append(found, d)
+	}
+	return found
+}
+
+// parseALSA reads the sound cards from /proc/asound/cards, where each card
+// starts with a line like " 1 [Device ]: USB-Audio - USB Audio Device"
+func parseALSA(output string) (devices []device) {
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "[") {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		card := len(devices)
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if n, err := strconv.Atoi(fields[0]); err == nil {
+				card = n
+			}
+		}
+		devices = append(devices, device{Name: line, Format: "alsa", Input: fmt.Sprintf("hw:%d", card)})
+	}
+	return
+}
+
+// parseALSAPCM reads the capture devices of every card from
+// /proc/asound/pcm, with lines like
+// "01-00: USB Audio : USB Audio : playback 1 : capture 1". Cards are named
+// from /proc/asound/cards.
+func parseALSAPCM(pcm, cards string) (devices []device) {
+	cardNames := make(map[string]string)
+	for _, card := range parseALSA(cards) {
+		name := card.Name
+		if _, long, ok := strings.Cut(name, " - "); ok {
+			name = long
+		}
+		cardNames[strings.TrimPrefix(card.Input, "hw:")] = strings.TrimSpace(name)
+	}
+	for _, line := range strings.Split(pcm, "\n") {
+		parts := strings.Split(line, " : ")
+		if len(parts) < 3 || !strings.HasPrefix(parts[len(parts)-1], "capture") {
+			continue
+		}
+		number, id, _ := strings.Cut(parts[0], ": ")
+		card, dev, ok := strings.Cut(strings.TrimSpace(number), "-")
+		if !ok {
+			continue
+		}
+		c, errCard := strconv.Atoi(card)
+		d, errDev := strconv.Atoi(dev)
+		if errCard != nil || errDev != nil {
+			continue
+		}
+		name := strings.TrimSpace(parts[1])
+		if name == "" {
+			name = strings.TrimSpace(id)
+		}
+		if cardName := cardNames[strconv.Itoa(c)]; cardName != "" && !strings.Contains(name, cardName) {
+			name = cardName + ": " + name
+		}
+		input := fmt.Sprintf("hw:%d,%d", c, d)
+		devices = append(devices, device{Name: fmt.Sprintf("%s (%s)", name, input), Format: "alsa", Input: input})
+	}
+	return
+}
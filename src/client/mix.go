@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+)
+
+// Input is another device mixed into the stream, like a loopback or a music
+// player next to the microphone
+type Input struct {
+	// Device is a name or index, like Client.Device
+	Device string `json:"device"`
+	// Gain is in dB, 0 leaves the device as loud as it is
+	Gain float64 `json:"gain,omitempty"`
+}
+
+// ParseInput reads an input written as a device, optionally followed by @
+// and its gain in dB, like "Monitor of Speakers@-6"
+func ParseInput(s string) (in Input, err error) {
+	in.Device = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		gain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s[i+1:])), "db")
+		if in.Gain, err = strconv.ParseFloat(strings.TrimSpace(gain), 64); err != nil {
+			err = fmt.Errorf("'%s': the gain after @ should be in dB, like @-6", s)
+			return
+		}
+		in.Device = strings.TrimSpace(s[:i])
+	}
+	if in.Device == "" {
+		err = fmt.Errorf("'%s' has no device", s)
+	}
+	return
+}
+
+// mixGraph is the filter graph that sets the gain of every input, mixes
+// them, measures the levels of the mix with levelFilter and then processes
+// it with the filters of process. The result is [stream]. If lossless is
+// set, the mix before processing is also [lossless].
+func mixGraph(gains []float64, process string, lossless bool) string {
+	var graph strings.Builder
+	for i, gain := range gains {
+		fmt.Fprintf(&graph, "[%d:a]volume=%gdB[in%d];", i, gain, i)
+	}
+	for i := range gains {
+		fmt.Fprintf(&graph, "[in%d]", i)
+	}
+	if len(gains) > 1 {
+		// the stream ends with the first input, and normalize=0 keeps every
+		// input as loud as its gain says
+		fmt.Fprintf(&graph, "amix=inputs=%d:duration=first:normalize=0,", len(gains))
+	}
+	if lossless {
+		graph.WriteString("asplit=3[mixed][meter][lossless];")
+	} else {
+		graph.WriteString("asplit=2[mixed][meter];")
+	}
+	if process == "" {
+		process = "anull"
+	}
+	graph.WriteString("[meter]" + meterChain + ";[mixed]" + process + "[stream]")
+	return graph.String()
+}
+
+// selectMix finds the devices of Mix. If ask is set, it also asks for more
+// devices to mix in.
+func (c *Client) selectMix(devices []device, ask bool) (inputs []input, err error) {
+	for i, in := range c.Mix {
+		var d device
+		if d, err = findDevice(devices, in.Device); err != nil {
+			return
+		}
+		// indexes can change, profiles keep the name
+		c.Mix[i].Device = d.Name
+		inputs = append(inputs, input{d.args(), in.Gain})
+	}
+	if !ask {
+		return
+	}
+	items := []string{"no, that is all"}
+	for _, d := range devices {
+		items = append(items, d.Name)
+	}
+	for {
+		prompt := promptui.Select{
+			Label: "mix in another device?",
+			Items: items,
+			Size:  min(len(items), 10),
+		}
+		var i int
+		if i, _, err = prompt.Run(); err != nil || i == 0 {
+			return
+		}
+		d := devices[i-1]
+		c.Mix = append(c.Mix, Input{Device: d.Name})
+		inputs = append(inputs, input{d.args(), 0})
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInput(t *testing.T) {
+	for s, want := range map[string]Input{
+		"USB Mic":                  {Device: "USB Mic"},
+		"Monitor of Speakers@-6":   {Device: "Monitor of Speakers", Gain: -6},
+		" 2 @ +3.5dB":              {Device: "2", Gain: 3.5},
+		"mic@home@-1":              {Device: "mic@home", Gain: -1},
+		"Loopback (hw:2,0) @ 0 dB": {Device: "Loopback (hw:2,0)"},
+	} {
+		if in, err := ParseInput(s); err != nil || in != want {
+			t.Errorf("%q: %+v, %v", s, in, err)
+		}
+	}
+	for _, bad := range []string{"", "mic@loud", "@-6"} {
+		if _, err := ParseInput(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestMixGraph(t *testing.T) {
+	graph := mixGraph([]float64{0, -6.5}, "highpass=f=80", true)
+	want := "[0:a]volume=0dB[in0];[1:a]volume=-6.5dB[in1];[in0][in1]amix=inputs=2:duration=first:normalize=0,asplit=3[mixed][meter][lossless];" +
+		"[meter]" + meterChain + ";[mixed]highpass=f=80[stream]"
+	if graph != want {
+		t.Errorf("mix\n%s\nnot\n%s", graph, want)
+	}
+	// the levels are measured before processing, even with one input
+	graph = mixGraph([]float64{3}, "", false)
+	if graph != "[0:a]volume=3dB[in0];[in0]asplit=2[mixed][meter];[meter]"+meterChain+";[mixed]anull[stream]" || strings.Contains(graph, "amix") {
+		t.Errorf("one input %s", graph)
+	}
+}
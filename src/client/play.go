@@ -0,0 +1,323 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/schollz/streammyaudio/src/ffmpeg"
+)
+
+// track is a file or URL streamed instead of a device
+type track struct {
+	// Input is what ffmpeg reads
+	Input string
+	// Title comes from the playlist, the track's own tags are used if it
+	// is empty
+	Title string
+}
+
+// isURL reports whether s is something ffmpeg fetches, like http://...
+func isURL(s string) bool {
+	scheme, _, ok := strings.Cut(s, "://")
+	return ok && len(scheme) > 1
+}
+
+// playlist reads the tracks of source. A local .m3u or .m3u8 file, or a
+// remote .m3u, is a playlist, anything else is played as it is.
+func playlist(source string) (tracks []track, err error) {
+	ext := strings.ToLower(path.Ext(source))
+	if isURL(source) {
+		if u, errParse := url.Parse(source); errParse == nil {
+			ext = strings.ToLower(path.Ext(u.Path))
+		}
+		// remote .m3u8 are HLS streams, which ffmpeg plays itself
+		if ext != ".m3u" {
+			return []track{{Input: source}}, nil
+		}
+	} else if ext != ".m3u" && ext != ".m3u8" {
+		if _, err = os.Stat(source); err != nil {
+			return
+		}
+		return []track{{Input: source}}, nil
+	}
+
+	var b []byte
+	if isURL(source) {
+		var resp *http.Response
+		resp, err = http.Get(source)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s: %s", source, resp.Status)
+			return
+		}
+		b, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	} else {
+		b, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return
+	}
+	tracks = parseM3U(string(b), source)
+	if len(tracks) == 0 {
+		err = fmt.Errorf("%s has no tracks", source)
+	}
+	return
+}
+
+// parseM3U reads the entries of a playlist, with the titles of "#EXTINF"
+// lines. Relative entries are relative to where the playlist is.
+func parseM3U(content, source string) (tracks []track) {
+	title := ""
+	for _, line := range strings.Split(strings.TrimPrefix(content, "\ufeff"), "\n") {
+		line = strings.TrimSpace(line)
+		if info, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			// #EXTINF:<seconds>,<title>
+			_, title, _ = strings.Cut(info, ",")
+			title = strings.TrimSpace(title)
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		input := line
+		if isURL(source) && !isURL(line) {
+			if base, err := url.Parse(source); err == nil {
+				if ref, err := url.Parse(line); err == nil {
+					input = base.ResolveReference(ref).String()
+				}
+			}
+		} else if !isURL(line) && !filepath.IsAbs(line) {
+			input = filepath.Join(filepath.Dir(source), filepath.FromSlash(line))
+		}
+		tracks = append(tracks, track{Input: input, Title: title})
+		title = ""
+	}
+	return
+}
+
+// tagTitle finds the title in what ffmpeg prints about its input, as
+// "artist - title" if there is an artist
+func tagTitle(header string) string {
+	tags := make(map[string]string)
+	for _, line := range strings.Split(header, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, seen := tags[key]; !seen && (key == "title" || key == "artist") {
+			tags[key] = strings.TrimSpace(value)
+		}
+	}
+	if tags["title"] == "" {
+		return ""
+	}
+	if tags["artist"] != "" {
+		return tags["artist"] + " - " + tags["title"]
+	}
+	return tags["title"]
+}
+
+// fileTitle is the name of a file or URL without its extension
+func fileTitle(input string) string {
+	name := path.Base(filepath.ToSlash(input))
+	if u, err := url.Parse(input); err == nil && isURL(input) {
+		name = path.Base(u.Path)
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// encoderWriter remembers whether writing to the encoder failed, which
+// ends the playlist
+type encoderWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoderWriter) Write(p []byte) (n int, err error) {
+	n, err = e.w.Write(p)
+	if err != nil {
+		e.err = err
+	}
+	return
+}
+
+// play decodes the tracks one after another into w, the stdin of the
+// encoder, each in real time. The encoder never notices where one track
+// ends and the next begins, so there are no gaps between them.
+func (c *Client) play(tracks []track, w io.WriteCloser, quit chan struct{}) {
+	defer w.Close()
+	order := append([]track{}, tracks...)
+	encoder := &encoderWriter{w: w}
+	for {
+		if c.Shuffle {
+			rand.Shuffle(len(order), func(i, j int) {
+				order[i], order[j] = order[j], order[i]
+			})
+		}
+		played := 0
+		for _, t := range order {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			err := c.playTrack(t, encoder, quit)
+			if encoder.err != nil {
+				return
+			}
+			select {
+			case <-quit:
+				// the track was stopped, it did not fail
+				return
+			default:
+			}
+			if err != nil {
+				c.printf("could not play %s: %s\n", t.Input, err)
+				continue
+			}
+			played++
+		}
+		// nothing would play the next time around either
+		if !c.Loop || played == 0 {
+			return
+		}
+	}
+}
+
+// playTrack decodes a track into the encoder, announcing its title once
+// ffmpeg has read the tags
+func (c *Client) playTrack(t track, encoder *encoderWriter, quit chan struct{}) (err error) {
+	args := append([]string{"-hide_banner", "-re", "-i", t.Input, "-vn"}, pcmFormat...)
+	cmd := exec.Command(ffmpeg.Binary(), append(args, "-")...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	// the input and its tags are described before the output
+	lastLine := make(chan string, 1)
+	go func() {
+		var header bytes.Buffer
+		announced := false
+		last := ""
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) != "" {
+				last = line
+			}
+			if announced {
+				continue
+			}
+			if strings.HasPrefix(line, "Output #") || strings.HasPrefix(line, "Stream mapping") {
+				announced = true
+				title := t.Title
+				if title == "" {
+					title = tagTitle(header.String())
+				}
+				if title == "" {
+					title = fileTitle(t.Input)
+				}
+				c.nowPlaying(title)
+				continue
+			}
+			header.WriteString(line + "\n")
+		}
+		lastLine <- last
+	}()
+
+	io.Copy(encoder, stdout)
+	if encoder.err != nil {
+		cmd.Process.Kill()
+	}
+	last := <-lastLine
+	if err = cmd.Wait(); err != nil && encoder.err == nil {
+		err = fmt.Errorf("%s", last)
+	}
+	return
+}
+
+// nowPlaying tells the listeners what is playing, on every server that
+// took the stream
+func (c *Client) nowPlaying(title string) {
+	c.printf("now playing: %s\n", title)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.title = title
+	for _, d := range c.dests {
+		if d.key != "" {
+			go postTitle(d.Server, d.broadcast, d.key, title)
+		}
+	}
+}
+
+// setBroadcast remembers where the stream went, and tells the server what
+// is playing
+func (c *Client) setBroadcast(d *destination, key string) {
+	c.mutex.Lock()
+	d.broadcast, d.key = d.Name, key
+	title := c.title
+	c.mutex.Unlock()
+	if title != "" {
+		go postTitle(d.Server, d.Name, key, title)
+	}
+}
+
+// postTitle sends now playing to a server as the stream's metadata
+func postTitle(server, name, key, title string) {
+	postAsHost(server, "/metadata/", name, key, map[string]string{"title": title})
+}
+
+// postAsHost POSTs body as JSON to route on a server, for the stream called
+// name, with its stream key
+func postAsHost(server, route, name, key string, body any) {
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", strings.TrimSuffix(server, "/")+route+url.PathEscape(name), bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		log.Debugf("%s: %s", route, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Debugf("%s: %s", route, resp.Status)
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+const ffmpegHeader = `Input #0, mp3, from 'song.mp3':
+  Metadata:
+    title           : Blue
+    artist          : Someone
+    album           : Colors
+  Duration: 00:03:12.05, start: 0.025057, bitrate: 320 kb/s
+  Stream #0:0: Audio: mp3, 44100 Hz, stereo, fltp, 320 kb/s
+    Metadata:
+      title           : Stream title
+`
+
+func TestParseM3U(t *testing.T) {
+	list := "#EXTM3U\n#EXTINF:61,Intro Jingle\r\nintro.mp3\n\n# a comment\nsets/one.flac\nhttps://example.com/two.mp3\n"
+	tracks := parseM3U(list, filepath.Join("shows", "list.m3u"))
+	if len(tracks) != 3 ||
+		tracks[0].Input != filepath.Join("shows", "intro.mp3") || tracks[0].Title != "Intro Jingle" ||
+		tracks[1].Input != filepath.Join("shows", "sets", "one.flac") || tracks[1].Title != "" ||
+		tracks[2].Input != "https://example.com/two.mp3" {
+		t.Errorf("local playlist %+v", tracks)
+	}
+
+	tracks = parseM3U("one.mp3\n/two.mp3\n", "https://example.com/shows/list.m3u")
+	if len(tracks) != 2 || tracks[0].Input != "https://example.com/shows/one.mp3" ||
+		tracks[1].Input != "https://example.com/two.mp3" {
+		t.Errorf("remote playlist %+v", tracks)
+	}
+}
+
+func TestTitles(t *testing.T) {
+	if title := tagTitle(ffmpegHeader); title != "Someone - Blue" {
+		t.Errorf("tag title %q", title)
+	}
+	if title := tagTitle("Input #0, wav, from 'a.wav':\n  Duration: 00:00:01.00\n"); title != "" {
+		t.Errorf("untagged title %q", title)
+	}
+	for input, title := range map[string]string{
+		filepath.Join("sets", "late night.flac"): "late night",
+		"https://example.com/radio/live.mp3?x=1": "live",
+	} {
+		if got := fileTitle(input); got != title {
+			t.Errorf("%s: %q", input, got)
+		}
+	}
+}
+
+func TestPlaylistSources(t *testing.T) {
+	if tracks, err := playlist("https://example.com/hls/live.m3u8"); err != nil || len(tracks) != 1 {
+		t.Errorf("hls stream %+v, %v", tracks, err)
+	}
+	if _, err := playlist(filepath.Join(t.TempDir(), "missing.mp3")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Presets of the processing chain
+const (
+	// PresetRaw leaves the audio as it is
+	PresetRaw = "raw"
+	// PresetVoice is for talking: cuts rumble, mutes the room between
+	// words and evens out the level
+	PresetVoice = "voice"
+	// PresetMusic is gentler, and louder like music usually is
+	PresetMusic = "music"
+)
+
+// Processing is how the audio is cleaned up before it is encoded. A zero
+// value leaves that step out.
+type Processing struct {
+	// Highpass cuts rumble below this frequency, in Hz
+	Highpass float64
+	// Gate mutes whatever is quieter than this, in dB
+	Gate float64
+	// Compress evens out the level above -18 dB with this ratio
+	Compress float64
+	// Loudness normalizes to this integrated loudness, in LUFS
+	Loudness float64
+	// Limit keeps the peaks under this, in dB
+	Limit float64
+}
+
+var presets = map[string]Processing{
+	PresetRaw:   {},
+	PresetVoice: {Highpass: 80, Gate: -50, Compress: 4, Loudness: -16, Limit: -1},
+	PresetMusic: {Highpass: 30, Compress: 2, Loudness: -14, Limit: -1},
+}
+
+// processing is the chain of the preset, with the steps the client set
+// instead
+func (c *Client) processing() (p Processing, err error) {
+	preset := strings.ToLower(strings.TrimSpace(c.Preset))
+	if preset == "" {
+		preset = PresetRaw
+	}
+	p, ok := presets[preset]
+	if !ok {
+		names := []string{}
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		err = fmt.Errorf("unknown preset '%s', use %s", c.Preset, strings.Join(names, ", "))
+		return
+	}
+	for _, step := range []struct {
+		name     string
+		value    *float64
+		min, max float64
+		to       *float64
+	}{
+		// ffmpeg takes ratios up to 20, and limits down to 1/16
+		{"highpass", c.Highpass, 0, 20000, &p.Highpass},
+		{"gate", c.Gate, -90, 0, &p.Gate},
+		{"compress", c.Compress, 1, 20, &p.Compress},
+		{"loudness", c.Loudness, -70, -5, &p.Loudness},
+		{"limit", c.Limit, -24, 0, &p.Limit},
+	} {
+		if step.value == nil {
+			continue
+		}
+		if v := *step.value; v != 0 && (v < step.min || v > step.max) {
+			err = fmt.Errorf("%s should be %g to %g, or 0 to leave it out, not %g", step.name, step.min, step.max, v)
+			return
+		}
+		*step.to = *step.value
+	}
+	return
+}
+
+// filters is the chain as ffmpeg filters, empty if it does nothing
+func (p Processing) filters() string {
+	filters := []string{}
+	if p.Highpass > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%g", p.Highpass))
+	}
+	if p.Gate != 0 {
+		filters = append(filters, fmt.Sprintf("agate=threshold=%g:ratio=4:attack=10:release=250", linear(p.Gate)))
+	}
+	if p.Compress > 1 {
+		filters = append(filters, fmt.Sprintf("acompressor=threshold=%g:ratio=%g:attack=20:release=250", linear(-18), p.Compress))
+	}
+	if p.Loudness != 0 {
+		tp := -1.5
+		if p.Limit != 0 {
+			tp = p.Limit
+		}
+		// loudnorm works at 192 kHz, the encoder wants the usual rate back
+		filters = append(filters, fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=11", p.Loudness, tp), "aresample=44100")
+	}
+	if p.Limit != 0 {
+		// level=0 stops the limiter from raising everything up to the limit
+		filters = append(filters, fmt.Sprintf("alimiter=limit=%g:level=0", linear(p.Limit)))
+	}
+	return strings.Join(filters, ",")
+}
+
+// linear is a level in dB as an amplitude, which some filters take
+func linear(dB float64) float64 {
+	return math.Round(math.Pow(10, dB/20)*1e5) / 1e5
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	for _, preset := range []string{"", PresetRaw} {
+		if p, err := (&Client{Preset: preset}).processing(); err != nil || p.filters() != "" {
+			t.Errorf("%q: %q, %v", preset, p.filters(), err)
+		}
+	}
+
+	p, err := (&Client{Preset: "Voice"}).processing()
+	want := "highpass=f=80,agate=threshold=0.00316:ratio=4:attack=10:release=250," +
+		"acompressor=threshold=0.12589:ratio=4:attack=20:release=250," +
+		"loudnorm=I=-16:TP=-1:LRA=11,aresample=44100,alimiter=limit=0.89125:level=0"
+	if err != nil || p.filters() != want {
+		t.Errorf("voice\n%s\nnot\n%s", p.filters(), want)
+	}
+
+	p, err = (&Client{Preset: PresetMusic, Loudness: value(-23)}).processing()
+	if err != nil || !strings.Contains(p.filters(), "loudnorm=I=-23:") || strings.Contains(p.filters(), "agate") {
+		t.Errorf("music %s, %v", p.filters(), err)
+	}
+
+	if _, err = (&Client{Preset: "podcast"}).processing(); err == nil || !strings.Contains(err.Error(), "music, raw, voice") {
+		t.Errorf("unknown preset %v", err)
+	}
+	if _, err = (&Client{Preset: PresetVoice, Loudness: value(3)}).processing(); err == nil {
+		t.Error("loudness of 3 LUFS accepted")
+	}
+}
+
+func TestProcessingSteps(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	for _, tc := range []struct {
+		name   string
+		client *Client
+		want   string
+		err    bool
+	}{
+		{
+			name:   "steps without a preset",
+			client: &Client{Highpass: value(100), Limit: value(-3)},
+			want:   "highpass=f=100,alimiter=limit=0.70795:level=0",
+		},
+		{
+			name:   "0 leaves a step of the preset out",
+			client: &Client{Preset: PresetVoice, Gate: value(0), Compress: value(0), Loudness: value(-20)},
+			want:   "highpass=f=80,loudnorm=I=-20:TP=-1:LRA=11,aresample=44100,alimiter=limit=0.89125:level=0",
+		},
+		{
+			name:   "replacing the preset's",
+			client: &Client{Preset: PresetMusic, Highpass: value(60), Gate: value(-40), Compress: value(3)},
+			want: "highpass=f=60,agate=threshold=0.01:ratio=4:attack=10:release=250," +
+				"acompressor=threshold=0.12589:ratio=3:attack=20:release=250," +
+				"loudnorm=I=-14:TP=-1:LRA=11,aresample=44100,alimiter=limit=0.89125:level=0",
+		},
+		{name: "negative highpass", client: &Client{Highpass: value(-80)}, err: true},
+		{name: "gate above 0 dB", client: &Client{Gate: value(6)}, err: true},
+		{
+			name:   "0 leaves the loudness out",
+			client: &Client{Preset: PresetMusic, Loudness: value(0)},
+			want:   "highpass=f=30,acompressor=threshold=0.12589:ratio=2:attack=20:release=250,alimiter=limit=0.89125:level=0",
+		},
+		{name: "compress too far", client: &Client{Compress: value(50)}, err: true},
+		{name: "loudness above -5 LUFS", client: &Client{Loudness: value(-2)}, err: true},
+		{name: "limit too low", client: &Client{Limit: value(-40)}, err: true},
+	} {
+		p, err := tc.client.processing()
+		if (err != nil) != tc.err {
+			t.Errorf("%q: %v", tc.name, err)
+		} else if !tc.err && p.filters() != tc.want {
+			t.Errorf("%q\n%s\nnot\n%s", tc.name, p.filters(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Profile is a saved set of choices for casting, so a stream can be started
+// without answering any questions
+type Profile struct {
+	Server string  `json:"server,omitempty"`
+	Name   string  `json:"name,omitempty"`
+	Device string  `json:"device,omitempty"`
+	Gain   float64 `json:"gain,omitempty"`
+	Mix    []Input `json:"mix,omitempty"`
+	Codec  string  `json:"codec,omitempty"`
+	Preset string  `json:"preset,omitempty"`
+	// Highpass, Gate, Compress, Loudness and Limit are only saved when
+	// they replace the preset's
+	Highpass  *float64 `json:"highpass,omitempty"`
+	Gate      *float64 `json:"gate,omitempty"`
+	Compress  *float64 `json:"compress,omitempty"`
+	Loudness  *float64 `json:"loudness,omitempty"`
+	Limit     *float64 `json:"limit,omitempty"`
+	Quality   *int     `json:"quality,omitempty"`
+	Advertise string   `json:"advertise,omitempty"`
+	Archive   string   `json:"archive,omitempty"`
+	Source    string   `json:"source,omitempty"`
+	Shuffle   bool     `json:"shuffle,omitempty"`
+	Loop      bool     `json:"loop,omitempty"`
+	Record    string   `json:"record,omitempty"`
+	Lossless  string   `json:"lossless,omitempty"`
+	// Silence is a duration like "5m"
+	Silence       string `json:"silence,omitempty"`
+	SilenceAction string `json:"silence_action,omitempty"`
+	// Targets are more servers the stream is cast to
+	Targets []Target `json:"targets,omitempty"`
+}
+
+// ProfilesFile is where profiles are kept: config if it is set, otherwise
+// streammyaudio/profiles.json in the user's config folder
+func ProfilesFile(config string) (filename string, err error) {
+	if config != "" {
+		filename = config
+		return
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return
+	}
+	filename = filepath.Join(dir, "streammyaudio", "profiles.json")
+	return
+}
+
+// loadProfiles reads all the profiles in a file, by name. A missing file
+// has no profiles.
+func loadProfiles(filename string) (profiles map[string]Profile, err error) {
+	profiles = make(map[string]Profile)
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &profiles); err != nil {
+		err = fmt.Errorf("%s: %w", filename, err)
+	}
+	return
+}
+
+// LoadProfile reads the profile called name from the profiles file
+func LoadProfile(config, name string) (p Profile, err error) {
+	filename, err := ProfilesFile(config)
+	if err != nil {
+		return
+	}
+	profiles, err := loadProfiles(filename)
+	if err != nil {
+		return
+	}
+	p, ok := profiles[name]
+	if !ok {
+		names := []string{}
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		err = fmt.Errorf("no profile '%s' in %s (have %v)", name, filename, names)
+	}
+	return
+}
+
+// SaveProfile adds or replaces the profile called name in the profiles file
+func SaveProfile(config, name string, p Profile) (filename string, err error) {
+	filename, err = ProfilesFile(config)
+	if err != nil {
+		return
+	}
+	profiles, err := loadProfiles(filename)
+	if err != nil {
+		return
+	}
+	profiles[name] = p
+	b, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return
+	}
+	err = os.WriteFile(filename, b, 0o644)
+	return
+}
+
+// Apply sets the choices of a profile, leaving the rest as they are
+func (c *Client) Apply(p Profile) {
+	if p.Server != "" {
+		c.Server = p.Server
+	}
+	if p.Name != "" {
+		c.Name = p.Name
+	}
+	if p.Device != "" {
+		c.Device = p.Device
+	}
+	if p.Gain != 0 {
+		c.Gain = p.Gain
+	}
+	if len(p.Mix) > 0 {
+		c.Mix = p.Mix
+	}
+	if p.Codec != "" {
+		c.Codec = p.Codec
+	}
+	if p.Preset != "" {
+		c.Preset = p.Preset
+	}
+	if p.Highpass != nil {
+		c.Highpass = p.Highpass
+	}
+	if p.Gate != nil {
+		c.Gate = p.Gate
+	}
+	if p.Compress != nil {
+		c.Compress = p.Compress
+	}
+	if p.Loudness != nil {
+		c.Loudness = p.Loudness
+	}
+	if p.Limit != nil {
+		c.Limit = p.Limit
+	}
+	if p.Quality != nil {
+		c.Quality = *p.Quality
+	}
+	if p.Advertise != "" {
+		c.Advertise = p.Advertise
+	}
+	if p.Archive != "" {
+		c.Archive = p.Archive
+	}
+	if p.Source != "" {
+		c.Source = p.Source
+	}
+	if p.Record != "" {
+		c.Record = p.Record
+	}
+	if p.Lossless != "" {
+		c.Lossless = p.Lossless
+	}
+	if silence, err := time.ParseDuration(p.Silence); err == nil && silence > 0 {
+		c.Silence = silence
+	}
+	if p.SilenceAction != "" {
+		c.SilenceAction = p.SilenceAction
+	}
+	if len(p.Targets) > 0 {
+		c.Targets = p.Targets
+	}
+	c.Shuffle = c.Shuffle || p.Shuffle
+	c.Loop = c.Loop || p.Loop
+}
+
+// profile is what was chosen for this stream
+func (c *Client) profile() Profile {
+	quality := c.Quality
+	device := c.Device
+	if c.Source != "" {
+		device = ""
+	} else if c.DeviceName != "" {
+		device = c.DeviceName
+	}
+	p := Profile{
+		Server:    c.Server,
+		Name:      c.Name,
+		Device:    device,
+		Gain:      c.Gain,
+		Mix:       c.Mix,
+		Codec:     c.Codec,
+		Preset:    c.Preset,
+		Highpass:  c.Highpass,
+		Gate:      c.Gate,
+		Compress:  c.Compress,
+		Loudness:  c.Loudness,
+		Limit:     c.Limit,
+		Quality:   &quality,
+		Advertise: yesNo(c.Advertise),
+		Archive:   yesNo(c.Archive),
+		Source:    c.Source,
+		Shuffle:   c.Shuffle,
+		Loop:      c.Loop,
+		Record:    c.Record,
+		Lossless:  c.Lossless,
+		Targets:   c.Targets,
+	}
+	if c.Silence > 0 {
+		p.Silence = c.Silence.String()
+		p.SilenceAction = c.SilenceAction
+	}
+	return p
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// recordingName fills in a filename template, where {name} is the stream
+// name and {time} is when the stream started
+func recordingName(template, name string, started time.Time) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.NewReplacer(
+		"{name}", name,
+		"{time}", started.Format("20060102-150405"),
+	).Replace(template)
+}
+
+// losslessArgs are the ffmpeg arguments of a second output with a lossless
+// copy of the capture, as WAV or FLAC depending on the extension
+func losslessArgs(filename string) (args []string, err error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".wav":
+		args = []string{"-c:a", "pcm_s16le"}
+	case ".flac":
+		args = []string{"-c:a", "flac"}
+	default:
+		err = fmt.Errorf("lossless recordings are .wav or .flac, not '%s'", filename)
+		return
+	}
+	args = append(args, "-y", filename)
+	return
+}
+
+// createRecording makes the folders of filename and creates it
+func createRecording(filename string) (f *os.File, err error) {
+	if err = os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return
+	}
+	return os.Create(filename)
+}
+
+// recorder copies the stream to a local file. If writing fails the
+// recording stops, but the stream goes on.
+type recorder struct {
+	f      *os.File
+	err    error
+	printf func(format string, a ...any)
+}
+
+func (r *recorder) Write(p []byte) (n int, err error) {
+	if r.err == nil {
+		if _, r.err = r.f.Write(p); r.err != nil {
+			r.printf("recording stopped: %s\n", r.err)
+		}
+	}
+	return len(p), nil
+}
+
+// stop asks ffmpeg to finish, so it can close its files properly, and kills
+// it if it does not
+func stop(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		// there are no interrupts on Windows
+		cmd.Process.Kill()
+		return
+	}
+	time.AfterFunc(5*time.Second, func() {
+		cmd.Process.Kill()
+	})
+}
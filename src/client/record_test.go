@@ -0,0 +1,22 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordingName(t *testing.T) {
+	started := time.Date(2024, 3, 9, 18, 5, 1, 0, time.Local)
+	if name := recordingName("shows/{name}-{time}.mp3", "late/night: live", started); name != "shows/late_night_ live-20240309-180501.mp3" {
+		t.Errorf("recording name %q", name)
+	}
+	if args, err := losslessArgs("show.FLAC"); err != nil || len(args) != 4 || args[1] != "flac" || args[3] != "show.FLAC" {
+		t.Errorf("flac %v, %v", args, err)
+	}
+	if args, err := losslessArgs("show.wav"); err != nil || args[1] != "pcm_s16le" {
+		t.Errorf("wav %v, %v", args, err)
+	}
+	if _, err := losslessArgs("show.ogg"); err == nil {
+		t.Error("ogg accepted")
+	}
+}
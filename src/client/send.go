@@ -0,0 +1,235 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// first wait before reconnecting, doubled after every failure
+	minBackoff = time.Second
+	// longest wait between reconnects, well within the server's resume grace
+	maxBackoff = 30 * time.Second
+	// a connection that takes no audio for this long while some is waiting
+	// is taken for dead
+	stallTimeout = 20 * time.Second
+)
+
+// errStop ends the stream without reconnecting
+type errStop struct {
+	msg string
+}
+
+func (e errStop) Error() string {
+	return e.msg
+}
+
+// send streams the spool to a destination until ffmpeg stops or quit is
+// closed. When the connection drops it keeps reconnecting, and resends
+// whatever the server did not get.
+func (c *Client) send(d *destination, sp *spool, quit chan struct{}) (err error) {
+	token := ""
+	// base is where the server's broadcast started in the spool, offset is
+	// where the next connection picks up
+	var base, offset int64
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if sp.finished(offset) {
+				return nil
+			}
+			c.status.setState(d, "reconnecting (attempt %d)", attempt)
+			d.printf("reconnecting in %s (attempt %d), %d kB of audio waiting\n", backoff, attempt, sp.pending(offset)/1000)
+			select {
+			case <-quit:
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+
+		var resp *http.Response
+		var r *spoolReader
+		var cancel context.CancelFunc
+		c.status.setState(d, "connecting")
+		resp, r, cancel, err = c.connect(d, sp, token)
+		if err != nil {
+			if _, ok := err.(errStop); ok {
+				return
+			}
+			select {
+			case <-quit:
+				return nil
+			default:
+			}
+			c.status.setState(d, "no connection")
+			d.printf("problem connecting: %s\n", err.Error())
+			continue
+		}
+
+		received, errReceived := strconv.ParseInt(resp.Header.Get("X-Received"), 10, 64)
+		if token != "" && resp.Header.Get("X-Owner-Token") == token && errReceived == nil {
+			// the server still has the broadcast, go on where it stopped
+			offset = base + received
+			if oldest := sp.oldest(); offset < oldest {
+				// the server never gets this part, and counts from after it
+				d.printf("%d kB of audio did not fit in the spool and is lost\n", (oldest-offset)/1000)
+				base += oldest - offset
+				offset = oldest
+			}
+			d.printf("reconnected, resending %d kB of audio\n", sp.pending(offset)/1000)
+		} else {
+			if token != "" {
+				d.printf("reconnected as a new broadcast, the archive starts over\n")
+			}
+			base = offset
+			token = resp.Header.Get("X-Owner-Token")
+			c.started(d, token, resp)
+			c.setBroadcast(d, token)
+		}
+		backoff = minBackoff
+		c.status.setState(d, "live")
+		r.start(offset)
+
+		stalled := make(chan struct{})
+		go func() {
+			defer cancel()
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stalled:
+					return
+				case <-ticker.C:
+				}
+				at, lastRead := r.position()
+				c.status.setSent(d, at)
+				if sp.pending(at) > 0 && time.Since(lastRead) > stallTimeout {
+					d.printf("no audio went out for %s\n", stallTimeout)
+					return
+				}
+			}
+		}()
+		body, errBody := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		close(stalled)
+		r.Close()
+		offset, _ = r.position()
+		if lost := r.dropped(); lost > 0 {
+			d.printf("%d kB of audio did not fit in the spool and is lost\n", lost/1000)
+			base += lost
+		}
+		c.status.setSent(d, offset)
+		if msg := strings.TrimSpace(string(body)); errBody == nil && msg != "" {
+			c.status.setState(d, "stopped by the server")
+			d.printf("\n%s\n", msg)
+			return errStop{msg}
+		}
+		if sp.finished(offset) {
+			return nil
+		}
+		select {
+		case <-quit:
+			return nil
+		default:
+		}
+		c.status.setState(d, "connection lost")
+		if errBody != nil {
+			d.printf("connection lost: %s\n", errBody)
+		} else {
+			d.printf("connection lost: the server ended the stream\n")
+		}
+	}
+}
+
+// connect starts a request that streams the spool to a destination.
+// Resuming a broadcast needs its owner token.
+func (c *Client) connect(d *destination, sp *spool, token string) (resp *http.Response, r *spoolReader, cancel context.CancelFunc, err error) {
+	query := "stream=true&advertise=" + d.Advertise + "&archive=" + d.Archive
+	if token != "" {
+		query += "&resume=" + url.QueryEscape(token)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r = sp.reader()
+	req := (&http.Request{
+		Method: "POST",
+		URL: &url.URL{
+			Scheme:   strings.Split(d.Server, "://")[0],
+			Host:     strings.Split(d.Server, "://")[1],
+			Path:     "/" + d.Name + ".mp3",
+			RawQuery: query,
+		},
+		Header:        make(http.Header),
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		ContentLength: -1,
+		Body:          r,
+	}).WithContext(ctx)
+
+	client := &http.Client{
+		Transport: http.DefaultTransport,
+		Timeout:   0,
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		r.Close()
+		cancel()
+		return
+	}
+	if resp.StatusCode == http.StatusOK {
+		return
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	r.Close()
+	cancel()
+	msg := strings.TrimSpace(string(body))
+	if resp.StatusCode >= 500 {
+		// the server or a proxy in front of it is having trouble
+		err = fmt.Errorf("%s", resp.Status)
+		return
+	}
+	err = errStop{msg}
+	d.printf("\n%s\n", msg)
+	if suggestion := resp.Header.Get("X-Suggested-Name"); resp.StatusCode == http.StatusConflict && suggestion != "" {
+		d.printf("to use the suggested name, run again with --cast-name %s\n\n", suggestion)
+	}
+	return
+}
+
+// started tells the broadcaster where the new broadcast is. In a terminal
+// the status view takes over from here.
+func (c *Client) started(d *destination, token string, resp *http.Response) {
+	if name := resp.Header.Get("X-Stream-Name"); name != "" && name != d.Name {
+		d.printf("\n'%s' is already live, streaming as '%s' instead\n", d.Name, name)
+		// the status view shows the name
+		c.status.mutex.Lock()
+		d.Name = name
+		c.status.mutex.Unlock()
+	}
+	lines := []string{
+		fmt.Sprintf("now streaming at %s/%s", d.Server, d.Name),
+		fmt.Sprintf("stream key (keep it secret, it edits the archive): %s", token),
+	}
+	// the link only makes its holder the host of the chat, the stream key
+	// stays out of it. Older servers have no host key.
+	if hostKey := resp.Header.Get("X-Host-Key"); hostKey != "" {
+		lines = append(lines, fmt.Sprintf("chat as the verified host at %s/%s?key=%s", d.Server, d.Name, hostKey))
+	}
+	c.showStatus(d, lines)
+	if c.interactive {
+		return
+	}
+	fmt.Printf("%s\n", strings.Join(lines[1:], "\n"))
+
+	fmt.Printf("\n\nnow streaming at\n")
+	fmt.Printf("\n%s/%s\n\n", d.Server, d.Name)
+	fmt.Printf("press Ctl+C to quit\n")
+}
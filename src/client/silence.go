@@ -0,0 +1,104 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What the client does once the stream has been silent for Client.Silence,
+// each also doing what the one before does
+const (
+	// SilenceWarn says so in the terminal
+	SilenceWarn = "warn"
+	// SilenceChat also tells the chat
+	SilenceChat = "chat"
+	// SilenceStop also ends the stream
+	SilenceStop = "stop"
+)
+
+// silenceLevel is the peak level in dB under which the input is silent
+const silenceLevel = -50.0
+
+// silenceAction is what to do about silence, SilenceWarn unless it is set
+func (c *Client) silenceAction() (action string, err error) {
+	action = strings.ToLower(strings.TrimSpace(c.SilenceAction))
+	switch action {
+	case "":
+		action = SilenceWarn
+	case SilenceWarn, SilenceChat, SilenceStop:
+	default:
+		err = fmt.Errorf("unknown silence action '%s', use %s, %s or %s", c.SilenceAction, SilenceWarn, SilenceChat, SilenceStop)
+	}
+	return
+}
+
+// quiet is how long the input has been silent, zero if it is not or if
+// there are no levels to tell. The status mutex must be held.
+func (s *status) quiet() time.Duration {
+	if s.quietSince.IsZero() || time.Since(s.levelsAt) > 2*time.Second {
+		return 0
+	}
+	return time.Since(s.quietSince)
+}
+
+// watchSilence acts once the input has been silent for Silence, until done
+// is closed. Stopping calls end.
+func (c *Client) watchSilence(action string, done chan struct{}, end func()) {
+	if c.Silence <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	acted := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		c.status.mutex.Lock()
+		quiet := c.status.quiet()
+		c.status.mutex.Unlock()
+		if quiet == 0 {
+			if acted {
+				c.printf("there is sound again\n")
+				acted = false
+			}
+			continue
+		}
+		if acted || quiet < c.Silence {
+			continue
+		}
+		acted = true
+		quiet = quiet.Round(time.Second)
+		c.printf("no sound for %s, is the input muted?\n", quiet)
+		if action == SilenceChat || action == SilenceStop {
+			c.postSilence(quiet)
+		}
+		if action == SilenceStop {
+			c.printf("ending the stream after %s of silence\n", quiet)
+			end()
+			return
+		}
+	}
+}
+
+// postSilence tells the chat on every server that the stream is silent,
+// returning once they all have been told
+func (c *Client) postSilence(quiet time.Duration) {
+	var wg sync.WaitGroup
+	c.mutex.Lock()
+	for _, d := range c.dests {
+		if d.key != "" {
+			wg.Add(1)
+			go func(server, name, key string) {
+				defer wg.Done()
+				postAsHost(server, "/silence/", name, key, map[string]float64{"seconds": quiet.Seconds()})
+			}(d.Server, d.broadcast, d.key)
+		}
+	}
+	c.mutex.Unlock()
+	wg.Wait()
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSilence(t *testing.T) {
+	for action, want := range map[string]string{"": SilenceWarn, "Chat": SilenceChat, SilenceStop: SilenceStop} {
+		if got, err := (&Client{SilenceAction: action}).silenceAction(); err != nil || got != want {
+			t.Errorf("%q: %q, %v", action, got, err)
+		}
+	}
+	if _, err := (&Client{SilenceAction: "shout"}).silenceAction(); err == nil {
+		t.Error("unknown action accepted")
+	}
+
+	c := &Client{}
+	c.readLevels(strings.NewReader(
+		"[Parsed_ametadata_3 @ 0x1] lavfi.astats.Overall.Peak_level=-inf\n" +
+			"[Parsed_ametadata_4 @ 0x1] lavfi.astats.Overall.RMS_level=-inf\n" +
+			"[Parsed_ametadata_3 @ 0x1] lavfi.astats.Overall.Peak_level=-62.5\n"))
+	quietSince := c.status.quietSince
+	if quietSince.IsZero() || c.status.quiet() <= 0 {
+		t.Fatal("silence not noticed")
+	}
+	c.status.quietSince = quietSince.Add(-time.Minute)
+	if quiet := c.status.quiet(); quiet < time.Minute {
+		t.Errorf("quiet for %s", quiet)
+	}
+	c.readLevels(strings.NewReader("[Parsed_ametadata_3 @ 0x1] lavfi.astats.Overall.Peak_level=-12.0\n"))
+	if c.status.quiet() != 0 {
+		t.Error("still quiet after sound")
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+const (
+	// spoolLimit is how much audio the spool keeps, over an hour at 128
+	// kbps. Older audio is dropped, even if a server never got it.
+	spoolLimit = 64 << 20
+	// spoolChunk is the size of the files the spool is kept in, it drops a
+	// whole file at a time
+	spoolChunk = 4 << 20
+)
+
+// spool keeps what ffmpeg encodes in temporary files, so audio that did not
+// reach the server can be sent again after reconnecting. Offsets count from
+// the start of the stream, also once the oldest audio has been dropped.
+type spool struct {
+	dir   string
+	limit int64
+	chunk int64
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// files hold the audio from first on, chunk bytes each
+	files []*os.File
+	first int64
+	size  int64
+	// done is set once ffmpeg stopped writing
+	done bool
+}
+
+func newSpool() (sp *spool, err error) {
+	dir, err := os.MkdirTemp("", "streammyaudio-*")
+	if err != nil {
+		return
+	}
+	sp = &spool{dir: dir, limit: spoolLimit, chunk: spoolChunk}
+	sp.cond = sync.NewCond(&sp.mutex)
+	return
+}
+
+// fill copies r into the spool until it ends
+func (sp *spool) fill(r io.Reader) {
+	defer func() {
+		sp.mutex.Lock()
+		sp.done = true
+		sp.cond.Broadcast()
+		sp.mutex.Unlock()
+	}()
+	buffer := make([]byte, 4096)
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			if errWrite := sp.write(buffer[:n]); errWrite != nil {
+				log.Error(errWrite)
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write adds b to the end of the spool, dropping the oldest files that
+// are past the limit. Only fill writes, so the size and the last file can be
+// read without the lock.
+func (sp *spool) write(b []byte) (err error) {
+	for len(b) > 0 {
+		at := sp.size % sp.chunk
+		if at == 0 {
+			var f *os.File
+			f, err = os.Create(filepath.Join(sp.dir, fmt.Sprintf("%d.mp3", sp.size/sp.chunk)))
+			if err != nil {
+				return
+			}
+			sp.mutex.Lock()
+			sp.files = append(sp.files, f)
+			sp.mutex.Unlock()
+		}
+		n := min(int64(len(b)), sp.chunk-at)
+		if _, err = sp.files[len(sp.files)-1].WriteAt(b[:n], at); err != nil {
+			return
+		}
+		b = b[n:]
+
+		sp.mutex.Lock()
+		sp.size += n
+		for sp.size-(sp.first+sp.chunk) >= sp.limit {
+			sp.files[0].Close()
+			os.Remove(sp.files[0].Name())
+			sp.files = sp.files[1:]
+			sp.first += sp.chunk
+		}
+		sp.cond.Broadcast()
+		sp.mutex.Unlock()
+	}
+	return
+}
+
+// oldest is the offset of the oldest audio still in the spool
+func (sp *spool) oldest() int64 {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.first
+}
+
+// finished reports whether ffmpeg stopped and everything up to offset was
+// sent
+func (sp *spool) finished(offset int64) bool {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.done && offset >= sp.size
+}
+
+// pending is how much of the spool is after offset
+func (sp *spool) pending(offset int64) int64 {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	return sp.size - max(offset, sp.first)
+}
+
+// wait returns once ffmpeg stopped writing
+func (sp *spool) wait() {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	for !sp.done {
+		sp.cond.Wait()
+	}
+}
+
+func (sp *spool) close() {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	for _, f := range sp.files {
+		f.Close()
+	}
+	os.RemoveAll(sp.dir)
+}
+
+// spoolReader is the body of a request to the server. It waits until start
+// says where to begin, since that depends on how much the server already
+// has, and then follows the spool as ffmpeg adds to it.
+type spoolReader struct {
+	sp       *spool
+	offset   int64
+	started  bool
+	closed   bool
+	lastRead time.Time
+	// skipped counts the audio that was dropped before it could be read
+	skipped int64
+}
+
+func (sp *spool) reader() *spoolReader {
+	return &spoolReader{sp: sp}
+}
+
+// start lets the reader go on from offset
+func (r *spoolReader) start(offset int64) {
+	r.sp.mutex.Lock()
+	defer r.sp.mutex.Unlock()
+	r.offset = offset
+	r.started = true
+	r.lastRead = time.Now()
+	r.sp.cond.Broadcast()
+}
+
+// Read reads the spool under its lock, as the file being read may be
+// dropped otherwise. A reader that fell behind what the spool keeps skips
+// ahead.
+func (r *spoolReader) Read(p []byte) (n int, err error) {
+	sp := r.sp
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	for !r.closed && (!r.started || (r.offset >= sp.size && !sp.done)) {
+		sp.cond.Wait()
+	}
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if r.offset < sp.first {
+		r.skipped += sp.first - r.offset
+		r.offset = sp.first
+	}
+	if r.offset >= sp.size {
+		return 0, io.EOF
+	}
+	f := sp.files[(r.offset-sp.first)/sp.chunk]
+	at := r.offset % sp.chunk
+	n, err = f.ReadAt(p[:min(int64(len(p)), sp.size-r.offset, sp.chunk-at)], at)
+	if n > 0 {
+		err = nil
+	}
+	r.offset += int64(n)
+	r.lastRead = time.Now()
+	return
+}
+
+// Close stops the reader, a pending Read returns
+func (r *spoolReader) Close() error {
+	r.sp.mutex.Lock()
+	defer r.sp.mutex.Unlock()
+	r.closed = true
+	r.sp.cond.Broadcast()
+	return nil
+}
+
+// position is how far the reader got, and when it last read
+func (r *spoolReader) position() (offset int64, lastRead time.Time) {
+	r.sp.mutex.Lock()
+	defer r.sp.mutex.Unlock()
+	return r.offset, r.lastRead
+}
+
+// dropped is how much audio the reader skipped because it was dropped
+func (r *spoolReader) dropped() int64 {
+	r.sp.mutex.Lock()
+	defer r.sp.mutex.Unlock()
+	return r.skipped
+}
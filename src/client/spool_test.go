@@ -0,0 +1,93 @@
+package client
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolResends(t *testing.T) {
+	sp, err := newSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+	pr, pw := io.Pipe()
+	go sp.fill(pr)
+	pw.Write([]byte("first second "))
+
+	// the connection drops after the server got "first "
+	r := sp.reader()
+	r.start(6)
+	b := make([]byte, 7)
+	if _, err = io.ReadFull(r, b); err != nil || string(b) != "second " {
+		t.Fatalf("read %q, %v", b, err)
+	}
+
+	// a closed reader gives up waiting for more
+	read := make(chan error, 1)
+	go func() {
+		_, err := r.Read(b)
+		read <- err
+	}()
+	r.Close()
+	select {
+	case err = <-read:
+		if err == nil {
+			t.Error("read from a closed reader")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read still waiting after close")
+	}
+
+	// the next connection resends from where the server stopped, and follows
+	// ffmpeg until it ends
+	r = sp.reader()
+	r.start(6)
+	go func() {
+		pw.Write([]byte("third"))
+		pw.Close()
+	}()
+	if b, err = io.ReadAll(r); err != nil || string(b) != "second third" {
+		t.Errorf("read %q, %v", b, err)
+	}
+	if !sp.finished(int64(len("first second third"))) {
+		t.Error("spool not finished")
+	}
+}
+
+func TestSpoolDrops(t *testing.T) {
+	sp, err := newSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+	sp.limit, sp.chunk = 8, 4
+	sp.fill(strings.NewReader("0123456789abcdef"))
+
+	// only whole chunks are dropped, and never the last limit bytes
+	if sp.oldest() != 8 || sp.pending(0) != 8 || sp.pending(10) != 6 {
+		t.Errorf("oldest %d, pending %d", sp.oldest(), sp.pending(0))
+	}
+	for _, tc := range []struct {
+		start   int64
+		want    string
+		skipped int64
+	}{
+		{0, "89abcdef", 8},
+		{5, "89abcdef", 3},
+		{8, "89abcdef", 0},
+		{14, "ef", 0},
+	} {
+		r := sp.reader()
+		r.start(tc.start)
+		b, err := io.ReadAll(r)
+		if err != nil || string(b) != tc.want || r.dropped() != tc.skipped {
+			t.Errorf("from %d read %q, skipped %d, %v", tc.start, b, r.dropped(), err)
+		}
+	}
+	if !sp.finished(16) {
+		t.Error("spool not finished")
+	}
+}
@@ -0,0 +1,318 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/schollz/streammyaudio/src/chat"
+	"github.com/schollz/streammyaudio/src/clearscreen"
+)
+
+const (
+	// how often the status view is redrawn
+	redrawEvery = 250 * time.Millisecond
+	// how often the server is asked how many are listening
+	listenersEvery = 10 * time.Second
+	// the bitrate is averaged over this long
+	bitrateWindow = 5 * time.Second
+	// the quietest level the meters show
+	meterFloor = -60.0
+	// how many of the latest messages the view keeps
+	keepMessages = 6
+)
+
+// keys of the levels ffmpeg prints about the input, see levelFilter
+const (
+	peakKey = "lavfi.astats.Overall.Peak_level"
+	rmsKey  = "lavfi.astats.Overall.RMS_level"
+)
+
+// levelFilter measures the input every 100 ms or so, and prints the peak
+// and RMS levels in dBFS on stderr for readLevels
+var levelFilter = "asetnsamples=n=4410:p=0,astats=metadata=1:reset=1," +
+	"ametadata=mode=print:key=" + peakKey + ",ametadata=mode=print:key=" + rmsKey
+
+// meterChain measures a copy of the audio with levelFilter and then drops it
+var meterChain = levelFilter + ",anullsink"
+
+// status is what the caster knows while it streams, besides what it knows
+// about each destination. In a terminal it is drawn in place of the banner,
+// otherwise its messages are just printed.
+type status struct {
+	mutex sync.Mutex
+	view  bool
+	// rows drawn the last time, to draw over them
+	drawn int
+
+	messages []string
+	started  time.Time
+
+	peak, rms float64
+	levelsAt  time.Time
+	// when the input went silent, see silenceLevel
+	quietSince time.Time
+}
+
+// sample is how much was sent by some time
+type sample struct {
+	at   time.Time
+	sent int64
+}
+
+// printf prints a message, or adds it to the status view if it is showing
+func (c *Client) printf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	s := &c.status
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, line := range strings.Split(msg, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			s.messages = append(s.messages, line)
+		}
+	}
+	if len(s.messages) > keepMessages {
+		s.messages = s.messages[len(s.messages)-keepMessages:]
+	}
+	if !s.view {
+		fmt.Print(msg)
+	}
+}
+
+// showStatus replaces the banner with the status view once the stream is
+// live somewhere, with header lines saying where
+func (c *Client) showStatus(d *destination, header []string) {
+	s := &c.status
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d.header = header
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	if c.interactive && !s.view {
+		clearscreen.ClearScreen()
+		s.view = true
+		s.drawn = 0
+	}
+}
+
+// setState sets what is going on with the connection to d
+func (s *status) setState(d *destination, format string, a ...any) {
+	s.mutex.Lock()
+	d.state = fmt.Sprintf(format, a...)
+	s.mutex.Unlock()
+}
+
+// setSent sets how far into the stream the server at d got
+func (s *status) setSent(d *destination, at int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if at > d.sent {
+		d.sent = at
+	}
+	now := time.Now()
+	d.rates = append(d.rates, sample{now, d.sent})
+	for len(d.rates) > 2 && now.Sub(d.rates[1].at) > bitrateWindow {
+		d.rates = d.rates[1:]
+	}
+}
+
+// bitrate is how fast the stream went out to d lately, in kbps
+func (d *destination) bitrate() float64 {
+	if len(d.rates) < 2 {
+		return 0
+	}
+	first, last := d.rates[0], d.rates[len(d.rates)-1]
+	seconds := last.at.Sub(first.at).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(last.sent-first.sent) * 8 / seconds / 1000
+}
+
+// readLevels follows the levels the encoder prints on stderr
+func (c *Client) readLevels(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		key, level, ok := parseLevel(scanner.Text())
+		if !ok {
+			continue
+		}
+		s := &c.status
+		s.mutex.Lock()
+		if key == peakKey {
+			s.peak = level
+			if level >= silenceLevel {
+				s.quietSince = time.Time{}
+			} else if s.quietSince.IsZero() {
+				s.quietSince = time.Now()
+			}
+		} else {
+			s.rms = level
+		}
+		s.levelsAt = time.Now()
+		s.mutex.Unlock()
+	}
+	// ffmpeg has to be able to keep writing even if the lines are too long
+	io.Copy(io.Discard, stderr)
+}
+
+// parseLevel reads a level out of a line like
+// "[Parsed_ametadata_3 @ 0x5581] lavfi.astats.Overall.Peak_level=-6.02"
+func parseLevel(line string) (key string, level float64, ok bool) {
+	for _, key = range []string{peakKey, rmsKey} {
+		i := strings.Index(line, key+"=")
+		if i < 0 {
+			continue
+		}
+		var err error
+		level, err = strconv.ParseFloat(strings.TrimSpace(line[i+len(key)+1:]), 64)
+		return key, level, err == nil
+	}
+	return "", 0, false
+}
+
+// watchStatus redraws the status view and counts the listeners until done
+// is closed, and then draws it one last time
+func (c *Client) watchStatus(done chan struct{}) {
+	redraw := time.NewTicker(redrawEvery)
+	defer redraw.Stop()
+	var counted time.Time
+	for {
+		select {
+		case <-done:
+			c.drawStatus()
+			c.status.mutex.Lock()
+			c.status.view = false
+			c.status.mutex.Unlock()
+			return
+		case <-redraw.C:
+		}
+		if time.Since(counted) > listenersEvery {
+			counted = time.Now()
+			for _, d := range c.dests {
+				go c.countListeners(d)
+			}
+		}
+		c.drawStatus()
+	}
+}
+
+// countListeners asks the server at d how many are listening
+func (c *Client) countListeners(d *destination) {
+	c.mutex.Lock()
+	name := d.broadcast
+	c.mutex.Unlock()
+	if name == "" {
+		return
+	}
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(strings.TrimSuffix(d.Server, "/") + "/presence/" + url.PathEscape(name))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var p chat.Presence
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&p) != nil {
+		return
+	}
+	c.status.mutex.Lock()
+	d.listeners, d.counted = p.Listeners, true
+	c.status.mutex.Unlock()
+}
+
+// drawStatus draws the status view over the last one
+func (c *Client) drawStatus() {
+	s := &c.status
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.view {
+		return
+	}
+	lines := s.lines(c.dests)
+	width := readline.GetScreenWidth()
+	var b strings.Builder
+	if s.drawn > 0 {
+		fmt.Fprintf(&b, "\r\033[%dA", s.drawn)
+	}
+	b.WriteString("\033[J")
+	s.drawn = 0
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+		// long lines wrap onto more rows
+		s.drawn++
+		if n := len([]rune(line)); width > 0 && n > width {
+			s.drawn += (n - 1) / width
+		}
+	}
+	io.WriteString(readline.Stdout, b.String())
+}
+
+// lines are what the status view shows: each destination, the input, and
+// the latest messages
+func (s *status) lines(dests []*destination) (lines []string) {
+	for _, d := range dests {
+		if len(d.header) == 0 {
+			lines = append(lines, fmt.Sprintf("streaming to %s/%s", d.Server, d.Name))
+		}
+		lines = append(lines, d.header...)
+		listeners := "?"
+		if d.counted {
+			listeners = fmt.Sprint(d.listeners)
+		}
+		lines = append(lines,
+			fmt.Sprintf("  %-10s %s", "connection", d.state),
+			fmt.Sprintf("  %-10s %.1f MB, %.0f kbps", "sent", float64(d.sent)/1e6, d.bitrate()),
+			fmt.Sprintf("  %-10s %s", "listeners", listeners),
+			"",
+		)
+	}
+	if s.levelsAt.IsZero() || time.Since(s.levelsAt) > 2*time.Second {
+		lines = append(lines, "  input      no levels yet")
+	} else {
+		lines = append(lines,
+			fmt.Sprintf("  peak  %6s dB  %s", dB(s.peak), meter(s.peak)),
+			fmt.Sprintf("  rms   %6s dB  %s", dB(s.rms), meter(s.rms)),
+		)
+	}
+	if quiet := s.quiet(); quiet > 10*time.Second {
+		lines = append(lines, fmt.Sprintf("  %-10s no sound for %s", "silence", quiet.Truncate(time.Second)))
+	}
+	elapsed := time.Since(s.started).Truncate(time.Second)
+	lines = append(lines, fmt.Sprintf("  %-10s %02d:%02d:%02d", "elapsed", int(elapsed.Hours()), int(elapsed.Minutes())%60, int(elapsed.Seconds())%60))
+	if len(s.messages) > 0 {
+		lines = append(lines, "")
+		for _, msg := range s.messages {
+			lines = append(lines, "  "+msg)
+		}
+	}
+	lines = append(lines, "", "press Ctl+C to quit")
+	return
+}
+
+// dB shows a level, which is -inf in silence
+func dB(level float64) string {
+	if math.IsInf(level, -1) || level < -99 {
+		return "-inf"
+	}
+	return fmt.Sprintf("%.1f", level)
+}
+
+// meter is a bar as long as level is loud, from meterFloor to 0 dBFS
+func meter(level float64) string {
+	const width = 40
+	n := 0
+	if !math.IsInf(level, -1) && level > meterFloor {
+		n = int(math.Round((level - meterFloor) / -meterFloor * width))
+	}
+	n = max(0, min(width, n))
+	return "[" + strings.Repeat("#", n) + strings.Repeat("-", width-n) + "]"
+}
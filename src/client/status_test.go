@@ -0,0 +1,72 @@
+package client
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	key, level, ok := parseLevel("[Parsed_ametadata_3 @ 0x5581c0] lavfi.astats.Overall.Peak_level=-6.020600")
+	if !ok || key != peakKey || math.Abs(level+6.0206) > 1e-9 {
+		t.Errorf("peak %s %v %v", key, level, ok)
+	}
+	key, level, ok = parseLevel("[Parsed_ametadata_4 @ 0x5581c0] lavfi.astats.Overall.RMS_level=-inf")
+	if !ok || key != rmsKey || !math.IsInf(level, -1) {
+		t.Errorf("silence %s %v %v", key, level, ok)
+	}
+	if _, _, ok = parseLevel("[Parsed_ametadata_3 @ 0x5581c0] frame:12   pts:52920   pts_time:1.2"); ok {
+		t.Error("frame line read as a level")
+	}
+
+	if m := meter(math.Inf(-1)); strings.Contains(m, "#") {
+		t.Errorf("silent meter %s", m)
+	}
+	if m := meter(-30); strings.Count(m, "#") != 20 {
+		t.Errorf("half meter %s", m)
+	}
+	if m := meter(3); strings.Contains(m, "-") {
+		t.Errorf("clipping meter %s", m)
+	}
+	if dB(math.Inf(-1)) != "-inf" || dB(-12.34) != "-12.3" {
+		t.Errorf("dB %s %s", dB(math.Inf(-1)), dB(-12.34))
+	}
+}
+
+func TestBitrate(t *testing.T) {
+	now := time.Now()
+	var s status
+	d := &destination{sent: 32000}
+	d.rates = []sample{{now.Add(-2 * time.Second), 0}, {now, 32000}}
+	if rate := d.bitrate(); math.Abs(rate-128) > 1e-9 {
+		t.Errorf("bitrate %v", rate)
+	}
+	s.setSent(d, 10)
+	if d.sent != 32000 {
+		t.Errorf("sent went back to %d", d.sent)
+	}
+}
+
+func TestMeterBeforeProcessing(t *testing.T) {
+	c := &Client{Preset: PresetVoice}
+	cmd, err := c.command([]input{{args: []string{"-i", "mic"}}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filters string
+	for i, arg := range cmd.Args {
+		if arg == "-af" {
+			filters = cmd.Args[i+1]
+		}
+	}
+	want := "asplit=2[main][meter];[meter]" + levelFilter + ",anullsink;[main]highpass=f=80,"
+	if !strings.HasPrefix(filters, want) || strings.Count(filters, "astats=") != 1 {
+		t.Errorf("filters %s", filters)
+	}
+	// without processing the audio goes through as it is
+	cmd, _ = (&Client{}).command([]input{{args: []string{"-i", "mic"}}}, "")
+	if !strings.HasSuffix(strings.Join(cmd.Args, " "), ";[main]anull -f mp3 -q:a 0 -") {
+		t.Errorf("raw %s", cmd.Args)
+	}
+}
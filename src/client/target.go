@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target is another server the stream is cast to at the same time, from
+// the same capture. Settings left empty are the client's own.
+type Target struct {
+	Server    string `json:"server"`
+	Name      string `json:"name,omitempty"`
+	Advertise string `json:"advertise,omitempty"`
+	Archive   string `json:"archive,omitempty"`
+}
+
+// ParseTarget reads a target written as a server address followed by any
+// of name=, advertise= and archive=, separated by commas, like
+// "https://streammyaudio.com,name=my show,archive=no"
+func ParseTarget(s string) (t Target, err error) {
+	parts := strings.Split(s, ",")
+	t.Server = strings.TrimSuffix(strings.TrimSpace(parts[0]), "/")
+	if scheme, host, ok := strings.Cut(t.Server, "://"); !ok || scheme == "" || host == "" {
+		err = fmt.Errorf("target '%s' does not start with a server like https://streammyaudio.com", s)
+		return
+	}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "name":
+			t.Name = value
+		case "advertise":
+			t.Advertise = yesNo(value)
+		case "archive":
+			t.Archive = yesNo(value)
+		default:
+			err = fmt.Errorf("target '%s': unknown setting '%s', use name=, advertise= or archive=", s, part)
+			return
+		}
+	}
+	return
+}
+
+// destination is one of the servers the stream goes to, and how it is
+// doing there
+type destination struct {
+	Target
+	// printf prints a message about this destination
+	printf func(format string, a ...any)
+
+	// where the broadcast went once the server took it, for now playing,
+	// guarded by the client's mutex
+	broadcast string
+	key       string
+
+	// what the status view shows, guarded by the status mutex
+	header    []string
+	state     string
+	sent      int64
+	rates     []sample
+	listeners int
+	counted   bool
+}
+
+// destinations are the client's own server followed by the targets, with
+// what they leave out filled in
+func (c *Client) destinations() (dests []*destination) {
+	targets := append([]Target{{Server: c.Server, Name: c.Name, Advertise: c.Advertise, Archive: c.Archive}}, c.Targets...)
+	for _, t := range targets {
+		if t.Name == "" {
+			t.Name = c.Name
+		}
+		if t.Advertise == "" {
+			t.Advertise = c.Advertise
+		}
+		if t.Archive == "" {
+			t.Archive = c.Archive
+		}
+		// the server reads true or false
+		t.Advertise = fmt.Sprint(isYes(t.Advertise))
+		t.Archive = fmt.Sprint(isYes(t.Archive))
+		d := &destination{Target: t, printf: c.printf}
+		if len(targets) > 1 {
+			d.printf = func(format string, a ...any) {
+				c.printf("%s: %s", t.Server, fmt.Sprintf(strings.TrimLeft(format, "\n"), a...))
+			}
+		}
+		dests = append(dests, d)
+	}
+	return
+}
@@ -0,0 +1,27 @@
+package client
+
+import "testing"
+
+func TestTargets(t *testing.T) {
+	target, err := ParseTarget("https://streammyaudio.com/, name=my show ,archive=yes")
+	if err != nil || target != (Target{Server: "https://streammyaudio.com", Name: "my show", Archive: "yes"}) {
+		t.Errorf("target %+v, %v", target, err)
+	}
+	for _, bad := range []string{"streammyaudio.com", "https://", "http://localhost:9222,volume=11"} {
+		if _, err := ParseTarget(bad); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+
+	c := &Client{Server: "http://localhost:9222", Name: "show", Advertise: "true", Archive: "false", Targets: []Target{target}}
+	dests := c.destinations()
+	if len(dests) != 2 {
+		t.Fatalf("%d destinations", len(dests))
+	}
+	if d := dests[0]; d.Server != c.Server || d.Name != "show" || d.Advertise != "true" || d.Archive != "false" {
+		t.Errorf("own server %+v", d.Target)
+	}
+	if d := dests[1]; d.Server != target.Server || d.Name != "my show" || d.Advertise != "true" || d.Archive != "true" {
+		t.Errorf("target %+v", d.Target)
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Upload sends a pre-recorded file to the server so it is listed with the
+// archived broadcasts.
+func (c *Client) Upload(filename string) (err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	name := c.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		var errWrite error
+		defer func() {
+			pw.CloseWithError(errWrite)
+		}()
+		if errWrite = mw.WriteField("name", name); errWrite != nil {
+			return
+		}
+		part, errWrite := mw.CreateFormFile("file", filepath.Base(filename))
+		if errWrite != nil {
+			return
+		}
+		if _, errWrite = io.Copy(part, f); errWrite != nil {
+			return
+		}
+		errWrite = mw.Close()
+	}()
+
+	fmt.Printf("uploading %s...\n", filename)
+	resp, err := http.Post(strings.TrimSuffix(c.Server, "/")+"/upload", mw.FormDataContentType(), pr)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		err = fmt.Errorf("upload failed: %s", strings.TrimSpace(string(body)))
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("uploaded to %s/%s\n", strings.TrimSuffix(c.Server, "/"), strings.TrimSpace(string(body)))
+	fmt.Printf("owner token (keep it to rename, clip or remove the upload): %s\n", resp.Header.Get("X-Owner-Token"))
+	return
+}
@@ -0,0 +1,271 @@
+// Package irc bridges chat rooms to channels on an IRC server.
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/schollz/streammyaudio/src/chat"
+)
+
+// Via marks chat messages that came from IRC
+const Via = "irc"
+
+// DefaultMaxBackoff is the longest wait between reconnects, unless the
+// bridge sets MaxBackoff
+const DefaultMaxBackoff = 2 * time.Minute
+
+const (
+	// first wait before reconnecting, doubled after every failure
+	minBackoff = time.Second
+	// time allowed to write a line to the server
+	writeWait = 10 * time.Second
+	// IRC lines are at most 512 bytes, leave room for the prefix the server
+	// adds when passing them on
+	maxLineLength = 400
+	// lines waiting to go out, more are dropped while disconnected
+	queueSize = 100
+)
+
+// Bridge relays the messages of chat rooms to IRC channels and back. Chat
+// messages show up on IRC as "<name> text", and IRC messages in the chat
+// under the IRC nickname, marked as coming from IRC.
+type Bridge struct {
+	// Server is the host:port of the IRC server
+	Server string
+	// TLS connects to the server with TLS
+	TLS bool
+	// Nick is the bridge's nickname, "_" is added while it is taken
+	Nick string
+	// Channels maps chat rooms to IRC channels
+	Channels map[string]string
+	// MaxBackoff is the longest wait between reconnects, DefaultMaxBackoff
+	// if zero
+	MaxBackoff time.Duration
+
+	mutex sync.Mutex
+	// relays of the rooms, by lower case channel name
+	relays map[string]*chat.Relay
+	// lines for the server
+	queue chan string
+}
+
+// line is a message from the IRC server
+type line struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+// parseLine splits ":prefix COMMAND param param :trailing param"
+func parseLine(s string) (l line) {
+	if strings.HasPrefix(s, ":") {
+		l.prefix, s, _ = strings.Cut(s[1:], " ")
+	}
+	s, trailing, hasTrailing := strings.Cut(s, " :")
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return
+	}
+	l.command = strings.ToUpper(fields[0])
+	l.params = fields[1:]
+	if hasTrailing {
+		l.params = append(l.params, trailing)
+	}
+	return
+}
+
+// nick is the nickname in a "nick!user@host" prefix
+func (l line) nick() string {
+	nick, _, _ := strings.Cut(l.prefix, "!")
+	return nick
+}
+
+// Run relays messages until ctx is done, reconnecting to the IRC server
+// whenever the connection is lost
+func (b *Bridge) Run(ctx context.Context, hub *chat.Hub) (err error) {
+	if b.Server == "" || b.Nick == "" {
+		err = fmt.Errorf("irc bridge needs a server and a nick")
+		return
+	}
+	maxBackoff := b.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	b.relays = make(map[string]*chat.Relay)
+	b.queue = make(chan string, queueSize)
+	for room, channel := range b.Channels {
+		// subscribed before connecting, so nothing said on IRC is missed
+		r := b.subscribe(hub, room, channel)
+		if r == nil {
+			return
+		}
+		go b.relay(ctx, hub, room, channel, r)
+	}
+
+	backoff := minBackoff
+	for {
+		registered, errSession := b.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if registered {
+			backoff = minBackoff
+		}
+		log.Infof("irc: %s, reconnecting in %s", errSession, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// subscribe relays room to channel, it returns nil if the hub has shut down
+func (b *Bridge) subscribe(hub *chat.Hub, room, channel string) (r *chat.Relay) {
+	r = hub.Relay(room, Via)
+	if r != nil {
+		b.mutex.Lock()
+		b.relays[strings.ToLower(channel)] = r
+		b.mutex.Unlock()
+	}
+	return
+}
+
+// relay queues the messages of a room for the channel. The hub drops
+// relays that fall behind, so it subscribes again until ctx is done.
+func (b *Bridge) relay(ctx context.Context, hub *chat.Hub, room, channel string, r *chat.Relay) {
+	for r != nil {
+		for m := range r.Messages() {
+			text := fmt.Sprintf("<%s> %s", m.Name, m.Text)
+			if m.Type == chat.TypeSystem {
+				text = "* " + m.Text
+			}
+			b.send("PRIVMSG", channel, text)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(minBackoff):
+		}
+		r = b.subscribe(hub, room, channel)
+	}
+}
+
+// send queues a line for the server, dropping it if too many are waiting
+func (b *Bridge) send(command, target, text string) {
+	if len(text) > maxLineLength {
+		text = strings.ToValidUTF8(text[:maxLineLength], "")
+	}
+	select {
+	case b.queue <- fmt.Sprintf("%s %s :%s", command, target, text):
+	default:
+		log.Debugf("irc: dropped message for %s", target)
+	}
+}
+
+// session connects to the server and relays until the connection is lost.
+// It reports whether the server accepted the bridge's registration.
+func (b *Bridge) session(ctx context.Context) (registered bool, err error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if b.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", b.Server)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", b.Server)
+	}
+	if err != nil {
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	var writeMutex sync.Mutex
+	write := func(format string, a ...any) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		_, err := fmt.Fprintf(conn, format+"\r\n", a...)
+		return err
+	}
+
+	nick := b.Nick
+	if err = write("NICK %s", nick); err != nil {
+		return
+	}
+	if err = write("USER %s 0 * :streammyaudio chat bridge", b.Nick); err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		l := parseLine(strings.TrimRight(scanner.Text(), "\r"))
+		switch l.command {
+		case "PING":
+			write("PONG :%s", strings.Join(l.params, " "))
+		case "433":
+			// nickname in use
+			nick += "_"
+			write("NICK %s", nick)
+		case "001":
+			registered = true
+			log.Infof("irc: connected to %s as %s", b.Server, nick)
+			for _, channel := range b.Channels {
+				write("JOIN %s", channel)
+			}
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					case s := <-b.queue:
+						if write("%s", s) != nil {
+							return
+						}
+					}
+				}
+			}()
+		case "PRIVMSG":
+			if len(l.params) < 2 {
+				continue
+			}
+			b.mutex.Lock()
+			r := b.relays[strings.ToLower(l.params[0])]
+			b.mutex.Unlock()
+			text := l.params[1]
+			if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+				text = "* " + strings.TrimSuffix(action, "\x01")
+			} else if strings.HasPrefix(text, "\x01") {
+				// other CTCP requests are not for the chat
+				continue
+			}
+			if r != nil {
+				r.Send(l.nick(), text)
+			}
+		case "ERROR":
+			err = fmt.Errorf("server closed the connection: %s", strings.Join(l.params, " "))
+			return
+		}
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = fmt.Errorf("connection closed")
+	}
+	return
+}
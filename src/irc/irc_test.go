@@ -0,0 +1,172 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/streammyaudio/src/chat"
+)
+
+// standIn is an IRC server that hands each client connection to the test
+type standIn struct {
+	ln      net.Listener
+	clients chan *client
+}
+
+type client struct {
+	conn  net.Conn
+	lines *bufio.Scanner
+}
+
+func newStandIn(t *testing.T) *standIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &standIn{ln: ln, clients: make(chan *client, 4)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.clients <- &client{conn, bufio.NewScanner(conn)}
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *standIn) accept(t *testing.T) *client {
+	t.Helper()
+	select {
+	case c := <-s.clients:
+		t.Cleanup(func() { c.conn.Close() })
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not connect")
+	}
+	return nil
+}
+
+// expect reads lines until one starts with prefix
+func (c *client) expect(t *testing.T, prefix string) string {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for c.lines.Scan() {
+		if l := c.lines.Text(); strings.HasPrefix(l, prefix) {
+			return l
+		}
+	}
+	t.Fatalf("no %q from bridge: %v", prefix, c.lines.Err())
+	return ""
+}
+
+func (c *client) say(format string, a ...any) {
+	fmt.Fprintf(c.conn, format+"\r\n", a...)
+}
+
+// welcome registers the bridge the way a server would
+func (c *client) welcome(t *testing.T, nick string) {
+	t.Helper()
+	c.expect(t, "NICK "+nick)
+	c.expect(t, "USER ")
+	c.say(":irc.test 001 %s :Welcome", nick)
+	c.expect(t, "JOIN #show")
+}
+
+func startBridge(t *testing.T, server string) (hub *chat.Hub, stop context.CancelFunc) {
+	hub = chat.NewHub()
+	ctx, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+	go hub.Run(ctx)
+	b := &Bridge{
+		Server:     server,
+		Nick:       "sma",
+		Channels:   map[string]string{"show": "#show"},
+		MaxBackoff: time.Second,
+	}
+	go b.Run(ctx, hub)
+	return
+}
+
+// next waits for a chat message on a relay
+func next(t *testing.T, r *chat.Relay) chat.Message {
+	t.Helper()
+	select {
+	case m := <-r.Messages():
+		return m
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message in the chat")
+	}
+	return chat.Message{}
+}
+
+func TestParseLine(t *testing.T) {
+	l := parseLine(":alice!a@example.com PRIVMSG #show :hello there")
+	if l.nick() != "alice" || l.command != "PRIVMSG" || len(l.params) != 2 ||
+		l.params[0] != "#show" || l.params[1] != "hello there" {
+		t.Errorf("parsed %+v", l)
+	}
+	l = parseLine("PING irc.test")
+	if l.prefix != "" || l.command != "PING" || len(l.params) != 1 || l.params[0] != "irc.test" {
+		t.Errorf("parsed %+v", l)
+	}
+}
+
+func TestRelaysBothWays(t *testing.T) {
+	server := newStandIn(t)
+	hub, _ := startBridge(t, server.ln.Addr().String())
+	c := server.accept(t)
+
+	// the nickname is taken at first
+	c.expect(t, "NICK sma")
+	c.expect(t, "USER ")
+	c.say(":irc.test 433 * sma :Nickname is already in use")
+	c.expect(t, "NICK sma_")
+	c.say(":irc.test 001 sma_ :Welcome")
+	c.expect(t, "JOIN #show")
+	c.say("PING :irc.test")
+	c.expect(t, "PONG :irc.test")
+
+	// someone in the chat, as far as the bridge can tell
+	web := hub.Relay("show", "test")
+	c.say(":alice!a@example.com PRIVMSG #show :hi from irc")
+	c.say(":alice!a@example.com PRIVMSG #show :\x01ACTION waves\x01")
+	m := next(t, web)
+	if m.Name != "alice" || m.Text != "hi from irc" || m.Via != Via {
+		t.Errorf("relayed %+v", m)
+	}
+	if m = next(t, web); m.Text != "* waves" {
+		t.Errorf("relayed %+v", m)
+	}
+
+	web.Send("bob", "hi from the web")
+	if l := c.expect(t, "PRIVMSG"); l != "PRIVMSG #show :<bob> hi from the web" {
+		t.Errorf("sent %q", l)
+	}
+	if p := hub.Presence("show"); p.Chatters != 0 {
+		t.Errorf("relays counted as people: %+v", p)
+	}
+}
+
+func TestReconnects(t *testing.T) {
+	server := newStandIn(t)
+	hub, _ := startBridge(t, server.ln.Addr().String())
+	first := server.accept(t)
+	first.welcome(t, "sma")
+	first.conn.Close()
+
+	second := server.accept(t)
+	second.welcome(t, "sma")
+	web := hub.Relay("show", "test")
+	web.Send("bob", "still there?")
+	if l := second.expect(t, "PRIVMSG"); !strings.HasSuffix(l, "<bob> still there?") {
+		t.Errorf("sent %q", l)
+	}
+}
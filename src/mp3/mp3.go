@@ -0,0 +1,219 @@
+package mp3
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// Header is a decoded MPEG audio frame header
+type Header struct {
+	Version    int // 1 = MPEG-1, 2 = MPEG-2, 25 = MPEG-2.5
+	Layer      int
+	Bitrate    int // kbps
+	SampleRate int
+	Padding    bool
+}
+
+var bitrates = map[[2]int][16]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, -1},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, -1},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, -1},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, -1},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, -1},
+}
+
+var sampleRates = map[int][3]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+// ErrNoFrames is returned when a stream contains no MPEG audio frames
+var ErrNoFrames = errors.New("no mp3 frames found")
+
+// ParseHeader decodes the 4-byte frame header at the start of b
+func ParseHeader(b []byte) (h Header, ok bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return
+	}
+	switch (b[1] >> 3) & 0x03 {
+	case 0:
+		h.Version = 25
+	case 2:
+		h.Version = 2
+	case 3:
+		h.Version = 1
+	default:
+		return
+	}
+	h.Layer = 4 - int((b[1]>>1)&0x03)
+	if h.Layer == 4 {
+		return
+	}
+	table := h.Version
+	if table == 25 {
+		table = 2
+	}
+	h.Bitrate = bitrates[[2]int{table, h.Layer}][b[2]>>4]
+	if h.Bitrate <= 0 {
+		// free-format and invalid bitrates cannot be framed
+		return
+	}
+	srIndex := (b[2] >> 2) & 0x03
+	if srIndex == 3 {
+		return
+	}
+	h.SampleRate = sampleRates[h.Version][srIndex]
+	h.Padding = (b[2]>>1)&0x01 == 1
+	ok = true
+	return
+}
+
+// Samples returns the number of audio samples per channel in the frame
+func (h Header) Samples() int {
+	switch {
+	case h.Layer == 1:
+		return 384
+	case h.Layer == 3 && h.Version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// Length returns the size of the frame in bytes, including the header
+func (h Header) Length() int {
+	padding := 0
+	if h.Padding {
+		padding = 1
+	}
+	if h.Layer == 1 {
+		return (12*h.Bitrate*1000/h.SampleRate + padding) * 4
+	}
+	return h.Samples()/8*h.Bitrate*1000/h.SampleRate + padding
+}
+
+// Duration returns how much audio the frame holds
+func (h Header) Duration() time.Duration {
+	return time.Duration(h.Samples()) * time.Second / time.Duration(h.SampleRate)
+}
+
+// Clip copies the frames of r that start within [start, end) to w. Frames are
+// copied whole so the result plays without re-encoding. An end of zero copies
+// until the end of the stream.
+func Clip(w io.Writer, r io.Reader, start, end time.Duration) (n int64, err error) {
+	err = frames(r, func(frame []byte, position time.Duration) (more bool, err error) {
+		if end > 0 && position >= end {
+			return false, nil
+		}
+		if position >= start {
+			var m int
+			m, err = w.Write(frame)
+			n += int64(m)
+		}
+		return true, err
+	})
+	return
+}
+
+// Span is a part of a stream from Start to End. An End of zero is the end
+// of the stream.
+type Span struct {
+	Start, End time.Duration
+}
+
+// Cut copies the frames of r to w, leaving out those that start within one
+// of the cuts, which must be in order
+func Cut(w io.Writer, r io.Reader, cuts []Span) (n int64, err error) {
+	err = frames(r, func(frame []byte, position time.Duration) (more bool, err error) {
+		for len(cuts) > 0 && cuts[0].End > 0 && position >= cuts[0].End {
+			cuts = cuts[1:]
+		}
+		if len(cuts) == 0 || position < cuts[0].Start {
+			var m int
+			m, err = w.Write(frame)
+			n += int64(m)
+		}
+		return true, err
+	})
+	return
+}
+
+// frames calls fn with every frame of r and where it starts, until fn
+// returns false or an error. The Xing/Info frame is left out, it describes
+// the whole file and not what is made of it.
+func frames(r io.Reader, fn func(frame []byte, position time.Duration) (more bool, err error)) (err error) {
+	br := bufio.NewReaderSize(r, 8192)
+	if err = skipID3(br); err != nil {
+		return
+	}
+
+	var position time.Duration
+	found := false
+	first := true
+	frame := make([]byte, 0, 4096)
+	for {
+		b, errPeek := br.Peek(4)
+		if errPeek != nil {
+			break
+		}
+		h, ok := ParseHeader(b)
+		if !ok {
+			// resynchronize on the next byte
+			br.Discard(1)
+			continue
+		}
+		length := h.Length()
+		frame = frame[:length]
+		if _, errRead := io.ReadFull(br, frame); errRead != nil {
+			// drop the trailing partial frame
+			break
+		}
+		found = true
+		if first {
+			first = false
+			if isInfoFrame(frame) {
+				continue
+			}
+		}
+		more, errFn := fn(frame, position)
+		if errFn != nil {
+			return errFn
+		}
+		if !more {
+			break
+		}
+		position += h.Duration()
+	}
+	if !found {
+		err = ErrNoFrames
+	}
+	return
+}
+
+// skipID3 advances past a leading ID3v2 tag, if there is one
+func skipID3(br *bufio.Reader) (err error) {
+	b, err := br.Peek(10)
+	if err != nil || !bytes.HasPrefix(b, []byte("ID3")) {
+		return nil
+	}
+	size := int(b[6]&0x7F)<<21 | int(b[7]&0x7F)<<14 | int(b[8]&0x7F)<<7 | int(b[9]&0x7F)
+	size += 10
+	if b[5]&0x10 != 0 {
+		// footer present
+		size += 10
+	}
+	_, err = br.Discard(size)
+	return
+}
+
+func isInfoFrame(frame []byte) bool {
+	if len(frame) > 64 {
+		frame = frame[:64]
+	}
+	return bytes.Contains(frame, []byte("Xing")) || bytes.Contains(frame, []byte("Info"))
+}
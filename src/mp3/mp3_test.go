@@ -0,0 +1,141 @@
+package mp3
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// header128 is MPEG-1 layer III at 128 kbps and 44.1 kHz, 417 bytes and
+// 26.122 ms a frame
+var header128 = []byte{0xFF, 0xFB, 0x90, 0x00}
+
+// stream makes n frames of header, each numbered in the byte after the
+// header so tests can tell which were copied
+func stream(header []byte, n int) []byte {
+	h, _ := ParseHeader(header)
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		frame := make([]byte, h.Length())
+		copy(frame, header)
+		frame[4] = byte(i)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// numbers lists the frames in b made by stream
+func numbers(b []byte, header []byte) (n []int) {
+	h, _ := ParseHeader(header)
+	for i := 0; i+h.Length() <= len(b); i += h.Length() {
+		n = append(n, int(b[i+4]))
+	}
+	return
+}
+
+func TestParseHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		ok     bool
+		want   Header
+		length int
+		dur    time.Duration
+	}{
+		{"mpeg1 layer3", header128, true, Header{1, 3, 128, 44100, false}, 417, 26122448},
+		{"mpeg1 layer3 padded", []byte{0xFF, 0xFB, 0x92, 0x00}, true, Header{1, 3, 128, 44100, true}, 418, 26122448},
+		{"mpeg1 layer2", []byte{0xFF, 0xFD, 0xA4, 0x00}, true, Header{1, 2, 192, 48000, false}, 576, 24 * time.Millisecond},
+		{"mpeg1 layer1", []byte{0xFF, 0xFF, 0x94, 0x00}, true, Header{1, 1, 288, 48000, false}, 288, 8 * time.Millisecond},
+		{"mpeg2 layer3", []byte{0xFF, 0xF3, 0x80, 0x00}, true, Header{2, 3, 64, 22050, false}, 208, 26122448},
+		{"mpeg2.5 layer3", []byte{0xFF, 0xE3, 0x40, 0x00}, true, Header{25, 3, 32, 11025, false}, 208, 52244897},
+		{"bad sync", []byte{0xFF, 0x1B, 0x90, 0x00}, false, Header{}, 0, 0},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x00}, false, Header{}, 0, 0},
+		{"reserved layer", []byte{0xFF, 0xF9, 0x90, 0x00}, false, Header{}, 0, 0},
+		{"free bitrate", []byte{0xFF, 0xFB, 0x00, 0x00}, false, Header{}, 0, 0},
+		{"bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x00}, false, Header{}, 0, 0},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x00}, false, Header{}, 0, 0},
+		{"short", []byte{0xFF, 0xFB}, false, Header{}, 0, 0},
+	} {
+		h, ok := ParseHeader(tc.header)
+		if ok != tc.ok {
+			t.Errorf("%s: ok %v", tc.name, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if h != tc.want || h.Length() != tc.length || h.Duration() != tc.dur {
+			t.Errorf("%s: %+v, %d bytes, %s", tc.name, h, h.Length(), h.Duration())
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	audio := stream(header128, 100)
+	for _, tc := range []struct {
+		name       string
+		start, end time.Duration
+		first      int
+		count      int
+	}{
+		{"whole", 0, 0, 0, 100},
+		{"to the end", time.Second, 0, 39, 61},
+		{"middle", 100 * time.Millisecond, 500 * time.Millisecond, 4, 16},
+		{"on a boundary", 26122448, 2 * 26122448, 1, 1},
+		{"past the end", time.Hour, 2 * time.Hour, 0, 0},
+	} {
+		var w bytes.Buffer
+		n, err := Clip(&w, bytes.NewReader(audio), tc.start, tc.end)
+		got := numbers(w.Bytes(), header128)
+		if err != nil || n != int64(w.Len()) || len(got) != tc.count || (tc.count > 0 && got[0] != tc.first) {
+			t.Errorf("%s: %v, %d bytes, frames %v", tc.name, err, n, got)
+		}
+	}
+}
+
+func TestClipSkipsTags(t *testing.T) {
+	// a 20 byte ID3v2 tag, an Info frame and some noise before the audio
+	info := make([]byte, 417)
+	copy(info, header128)
+	copy(info[36:], "Info")
+	b := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x14"), make([]byte, 20)...)
+	b = append(b, info...)
+	b = append(b, 0x00, 0x12)
+	b = append(b, stream(header128, 10)...)
+	// and a partial frame at the end
+	b = append(b, header128...)
+
+	var w bytes.Buffer
+	if _, err := Clip(&w, bytes.NewReader(b), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := numbers(w.Bytes(), header128); len(got) != 10 || got[0] != 0 || w.Len() != 10*417 {
+		t.Errorf("frames %v, %d bytes", got, w.Len())
+	}
+
+	if _, err := Clip(&w, bytes.NewReader([]byte("not audio at all")), 0, 0); err != ErrNoFrames {
+		t.Errorf("not audio: %v", err)
+	}
+}
+
+func TestCut(t *testing.T) {
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name string
+		cuts []Span
+		want []int
+	}{
+		{"nothing", nil, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"frames starting in the cut", []Span{{50 * ms, 110 * ms}}, []int{0, 1, 5, 6, 7, 8, 9}},
+		{"start and to the end", []Span{{0, 30 * ms}, {200 * ms, 0}}, []int{2, 3, 4, 5, 6, 7}},
+		{"after the end", []Span{{time.Second, 2 * time.Second}}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	} {
+		var b bytes.Buffer
+		n, err := Cut(&b, bytes.NewReader(stream(header128, 10)), tc.cuts)
+		got := numbers(b.Bytes(), header128)
+		if err != nil || n != int64(b.Len()) || fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%q: kept %v, %d bytes, %v", tc.name, got, n, err)
+		}
+	}
+}
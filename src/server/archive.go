@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+// claimArchiveName keeps name for a new archive until madeArchive, unless
+// an archive is stored or being made under it
+func (s *Server) claimArchiveName(name string) bool {
+	s.naming.Lock()
+	defer s.naming.Unlock()
+	if s.making[name] {
+		return false
+	}
+	if _, err := s.Storage.Stat(name); !errors.Is(err, storage.ErrNotExist) {
+		return false
+	}
+	if s.making == nil {
+		s.making = make(map[string]bool)
+	}
+	s.making[name] = true
+	return true
+}
+
+// madeArchive lets go of the name of an archive that is complete, or that
+// was never made
+func (s *Server) madeArchive(name string) {
+	s.naming.Lock()
+	delete(s.making, name)
+	s.naming.Unlock()
+}
+
+// beingMade reports whether the archive at name is still being recorded,
+// uploaded or trimmed, so it cannot be edited or listed yet
+func (s *Server) beingMade(name string) bool {
+	s.naming.Lock()
+	defer s.naming.Unlock()
+	return s.making[name]
+}
+
+// renameArchive moves an archive along with its owner and chat. It never
+// replaces another archive.
+func (s *Server) renameArchive(filename, newname string) (err error) {
+	if newname == filename {
+		return fmt.Errorf("it is already called that")
+	}
+	if !s.claimArchiveName(newname) {
+		return fmt.Errorf("'%s' already exists", newname)
+	}
+	defer s.madeArchive(newname)
+	if err = s.Storage.Rename(filename, newname); err != nil {
+		return
+	}
+	// archives from before owner tokens have no meta, and only the admin
+	// may edit them
+	err = s.Storage.Rename(filename+metaExt, newname+metaExt)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		// without its owner nobody could edit it, so it goes back
+		if errBack := s.Storage.Rename(newname, filename); errBack != nil {
+			err = fmt.Errorf("%w, and could not move it back: %s", err, errBack)
+		}
+		return
+	}
+	s.moveRecent(filename, newname)
+	if err = s.Storage.Rename(filename+transcriptExt, newname+transcriptExt); errors.Is(err, storage.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// removeArchive deletes an archive along with its owner and chat
+func (s *Server) removeArchive(filename string) (err error) {
+	if err = s.Storage.Delete(filename); err != nil {
+		return
+	}
+	s.moveRecent(filename, "")
+	for _, name := range []string{filename + metaExt, filename + transcriptExt} {
+		if errDelete := s.Storage.Delete(name); errDelete != nil && !errors.Is(errDelete, storage.ErrNotExist) {
+			err = errDelete
+		}
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+func TestRenameArchive(t *testing.T) {
+	s := &Server{Storage: storage.NewLocal(t.TempDir())}
+	for name, owner := range map[string]string{"1/a.mp3": "ann", "1/b.mp3": "bob"} {
+		storage.WriteAll(s.Storage, name, []byte(name))
+		storage.WriteAll(s.Storage, name+transcriptExt, []byte("[]"))
+		if err := s.writeMeta(name, archiveMeta{Name: name, Owner: hashToken(owner)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// taking someone else's name would hand their archive over
+	if err := s.renameArchive("1/a.mp3", "1/b.mp3"); err == nil {
+		t.Fatal("renamed onto another archive")
+	}
+	if !s.authorized("1/b.mp3", "bob") || s.authorized("1/b.mp3", "ann") {
+		t.Error("the other archive changed hands")
+	}
+	if b, _ := storage.ReadAll(s.Storage, "1/b.mp3"); string(b) != "1/b.mp3" {
+		t.Errorf("the other archive is now %q", b)
+	}
+
+	// nor can it take the name of a recording that is not in storage yet
+	recording := s.newArchiveName("/c.mp3")
+	if err := s.renameArchive("1/a.mp3", recording); err == nil {
+		t.Error("renamed onto a recording")
+	}
+	s.madeArchive(recording)
+
+	if err := s.renameArchive("1/a.mp3", "2/c.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2/c.mp3", "2/c.mp3" + metaExt, "2/c.mp3" + transcriptExt} {
+		if _, err := s.Storage.Stat(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if !s.authorized("2/c.mp3", "ann") {
+		t.Error("renamed archive lost its owner")
+	}
+	if err := s.renameArchive("1/a.mp3", "3/d.mp3"); err == nil {
+		t.Error("renamed a missing archive")
+	}
+}
+
+func TestRemoveArchive(t *testing.T) {
+	s := &Server{Storage: storage.NewLocal(t.TempDir())}
+	storage.WriteAll(s.Storage, "1/a.mp3", []byte("audio"))
+	s.writeMeta("1/a.mp3", archiveMeta{Owner: hashToken("ann")})
+
+	// an archive without chat is removed all the same
+	if err := s.removeArchive("1/a.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := s.Storage.List(); len(infos) != 0 {
+		t.Errorf("left %+v", infos)
+	}
+	if err := s.removeArchive("1/a.mp3"); err == nil {
+		t.Error("removed it twice")
+	}
+}
+
+func TestArchiveBeingMade(t *testing.T) {
+	s := &Server{Storage: storage.NewLocal(t.TempDir())}
+	storage.WriteAll(s.Storage, "1/done.mp3", []byte("audio"))
+	// a recording that is being trimmed is already in storage
+	trimming := s.newArchiveName("/trimming.mp3")
+	storage.WriteAll(s.Storage, trimming, []byte("audio"))
+
+	listed := func() (names []string) {
+		for _, afile := range s.listArchived(map[string]struct{}{}) {
+			names = append(names, afile.FullFilename)
+		}
+		return
+	}
+	if !s.beingMade(trimming) || s.beingMade("1/done.mp3") {
+		t.Error("wrong archive being made")
+	}
+	if names := listed(); len(names) != 1 || names[0] != "archived/1/done.mp3" {
+		t.Errorf("listed %v while trimming", names)
+	}
+	s.madeArchive(trimming)
+	if names := listed(); len(names) != 2 {
+		t.Errorf("listed %v once trimmed", names)
+	}
+}
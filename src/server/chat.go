@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+// authenticateChat reports whether key is the stream key or the host key of
+// the live stream that room belongs to, or the admin token. Any of them makes
+// the holder the host of the room.
+func (s *Server) authenticateChat(room, key string) bool {
+	if key == "" {
+		return false
+	}
+	if s.AdminToken != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.AdminToken)) == 1 {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for p, src := range s.sources {
+		if streamName(p) != room {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(src.token)) == 1 ||
+			subtle.ConstantTimeCompare([]byte(key), []byte(src.hostKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// live reports whether a stream called name is being broadcast
+func (s *Server) live(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for p := range s.sources {
+		if streamName(p) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// countListeners counts everyone listening to the audio of the stream that
+// room belongs to
+func (s *Server) countListeners(room string) (n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for p, listeners := range s.channels {
+		if streamName(p) == room {
+			n += len(listeners)
+		}
+	}
+	return
+}
+
+// servePresence responds with who is in the chat room at /presence/<room>,
+// and how many are listening
+func (s *Server) servePresence(w http.ResponseWriter, r *http.Request) {
+	room := strings.TrimPrefix(r.URL.Path, "/presence/")
+	if room == "" {
+		http.Error(w, "no room specified", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(s.Chat.Presence(room))
+}
+
+// DefaultChatGrace is how long the chat of a stream stays open after the
+// stream ends, unless the server sets ChatGrace
+const DefaultChatGrace = 10 * time.Minute
+
+// allowChatRoom reports whether room belongs to a live stream, to a stream
+// that ended less than ChatGrace ago, or is one of the ChatRooms
+func (s *Server) allowChatRoom(room string) bool {
+	for _, name := range s.ChatRooms {
+		if name == room {
+			return true
+		}
+	}
+	s.mutex.Lock()
+	for p := range s.sources {
+		if streamName(p) == room {
+			s.mutex.Unlock()
+			return true
+		}
+	}
+	ended, ok := s.ended[room]
+	if ok && time.Since(ended) > s.ChatGrace {
+		delete(s.ended, room)
+		ok = false
+	}
+	// archives finish when their stream ends, which covers streams that
+	// ended before a restart, and uploads
+	for name, made := range s.recent {
+		if time.Since(made) > s.ChatGrace {
+			delete(s.recent, name)
+		} else if path.Base(streamName(name)) == room {
+			ok = true
+		}
+	}
+	s.mutex.Unlock()
+	return ok
+}
+
+// loadRecentArchives finds the archives made less than ChatGrace ago, whose
+// chat rooms are still open. After that the server keeps track of them
+// itself, so that storage is not listed every time a room is checked.
+func (s *Server) loadRecentArchives() {
+	infos, err := s.Storage.List()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, info := range infos {
+		if !isMetaFile(info.Name) && time.Since(info.Modified) < s.ChatGrace {
+			s.recent[info.Name] = info.Modified
+		}
+	}
+}
+
+// addRecent notes a new archive, which opens the chat room of its stream
+// for ChatGrace
+func (s *Server) addRecent(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.recent != nil {
+		s.recent[name] = time.Now()
+	}
+}
+
+// moveRecent follows an archive that was renamed, or removed when newname
+// is empty
+func (s *Server) moveRecent(name, newname string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	made, ok := s.recent[name]
+	delete(s.recent, name)
+	if ok && newname != "" {
+		s.recent[newname] = made
+	}
+}
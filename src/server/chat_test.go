@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/schollz/streammyaudio/src/chat"
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+// countingStorage counts how often the whole storage is listed
+type countingStorage struct {
+	storage.Storage
+	lists int
+}
+
+func (c *countingStorage) List() ([]storage.Info, error) {
+	c.lists++
+	return c.Storage.List()
+}
+
+func TestAllowChatRoom(t *testing.T) {
+	folder := t.TempDir()
+	local := storage.NewLocal(folder)
+	for _, name := range []string{"1/old.mp3", "2/recent.mp3"} {
+		storage.WriteAll(local, name, []byte("audio"))
+	}
+	// not a stream, even though it is recent
+	storage.WriteAll(local, "2/talk.mp3.json", []byte("{}"))
+	hourAgo := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(folder, "1", "old.mp3"), hourAgo, hourAgo)
+
+	counting := &countingStorage{Storage: local}
+	s := &Server{
+		Storage:   counting,
+		ChatRooms: []string{"lobby"},
+		ChatGrace: 10 * time.Minute,
+		sources:   map[string]*source{"/live.mp3": {}},
+		ended:     map[string]time.Time{"gone": hourAgo, "just-ended": time.Now()},
+		recent:    make(map[string]time.Time),
+	}
+	s.loadRecentArchives()
+
+	check := func(want map[string]bool) {
+		t.Helper()
+		for room, allowed := range want {
+			if s.allowChatRoom(room) != allowed {
+				t.Errorf("%s allowed: %v", room, !allowed)
+			}
+		}
+	}
+	check(map[string]bool{
+		"lobby": true, "live": true, "just-ended": true, "recent": true,
+		"gone": false, "old": false, "talk": false, "nobody": false,
+	})
+
+	// the server follows its archives from then on
+	storage.WriteAll(local, "3/talk.mp3", []byte("audio"))
+	s.addRecent("3/talk.mp3")
+	if err := s.renameArchive("2/recent.mp3", "2/renamed.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]bool{"talk": true, "renamed": true, "recent": false})
+	if err := s.removeArchive("3/talk.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]bool{"talk": false, "renamed": true})
+
+	if counting.lists != 1 {
+		t.Errorf("listed storage %d times", counting.lists)
+	}
+}
+
+func TestAuthenticateChat(t *testing.T) {
+	s := &Server{
+		Storage:    storage.NewLocal(t.TempDir()),
+		AdminToken: "admin",
+		sources:    map[string]*source{"/show.mp3": {token: "owner", hostKey: "host"}},
+	}
+	s.writeMeta("1/show.mp3", archiveMeta{Owner: hashToken("owner")})
+	for _, tc := range []struct {
+		room, key string
+		want      bool
+	}{
+		{"show", "owner", true},
+		{"show", "host", true},
+		{"show", "admin", true},
+		{"show", "", false},
+		{"show", "guess", false},
+		{"other", "host", false},
+	} {
+		if s.authenticateChat(tc.room, tc.key) != tc.want {
+			t.Errorf("%s with %q: %v", tc.room, tc.key, !tc.want)
+		}
+	}
+	// the host key is shared in a link, it must not edit the archive
+	if s.authorized("1/show.mp3", "host") || !s.authorized("1/show.mp3", "owner") {
+		t.Error("host key edits the archive")
+	}
+}
+
+func TestChatRoomWithSpace(t *testing.T) {
+	s := &Server{
+		Chat:      chat.NewHub(),
+		ChatGrace: time.Minute,
+		sources:   map[string]*source{"/my show.mp3": {token: "owner", hostKey: "host"}},
+		ended:     make(map[string]time.Time),
+	}
+	s.Chat.AllowRoom = s.allowChatRoom
+	s.Chat.Authenticate = s.authenticateChat
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go s.Chat.Run(ctx)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			s.Chat.ServeHTTP(w, r)
+		} else {
+			s.serveChat(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	// the browser has the path encoded, the page decodes it once
+	resp, err := http.Get(ts.URL + "/my%20show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{`href="/my%20show.mp3"`, "decodeURIComponent(document.location.pathname"} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("page does not have %s", want)
+		}
+	}
+
+	// and encodes it once for the socket
+	ws := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?room="
+	conn, _, err := websocket.DefaultDialer.Dial(ws+url.QueryEscape("my show")+"&key=host&name=dj", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var m chat.Message
+		if err = conn.ReadJSON(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type == chat.TypeAuth {
+			break
+		}
+	}
+	if _, resp, err = websocket.DefaultDialer.Dial(ws+url.QueryEscape("my%20show"), nil); err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("joined the room encoded twice")
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/schollz/streammyaudio/src/ffmpeg"
+	"github.com/schollz/streammyaudio/src/mp3"
+)
+
+// clipArchive writes the audio between start and end of filename to a new
+// archive next to it. If replace is set, the clip takes the place of the
+// original instead.
+func (s *Server) clipArchive(filename string, start, end time.Duration, replace bool) (newname string, err error) {
+	if end <= start {
+		err = fmt.Errorf("end must be after start")
+		return
+	}
+	if _, err = s.Storage.Stat(filename); err != nil {
+		return
+	}
+
+	ext := path.Ext(filename)
+	base := fmt.Sprintf("%s-clip-%s-%s", strings.TrimSuffix(filename, ext),
+		formatTimestamp(start), formatTimestamp(end))
+	newname = base + ext
+	for i := 2; !replace; i++ {
+		// an earlier clip of the same part is kept
+		if s.claimArchiveName(newname) {
+			defer s.madeArchive(newname)
+			break
+		}
+		newname = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	output := newname
+	if replace {
+		output = filename + ".clip" + ext
+	}
+
+	if strings.EqualFold(ext, ".mp3") {
+		err = s.clipMP3(filename, output, start, end)
+	} else {
+		err = s.clipFFmpeg(filename, output, start, end)
+	}
+	if err == nil && replace {
+		newname = filename
+		err = s.Storage.Rename(output, filename)
+	} else if meta, errMeta := s.readMeta(filename); err == nil && errMeta == nil {
+		// the clip belongs to whoever owns the original
+		meta.Name = path.Base(newname)
+		meta.Created = time.Now()
+		err = s.writeMeta(newname, meta)
+	}
+	if err != nil {
+		s.Storage.Delete(output)
+		return
+	}
+	// the chat is only cut once the audio is
+	if errChat := s.clipTranscript(filename, newname, start, end); errChat != nil {
+		log.Error(errChat)
+	}
+	s.addRecent(newname)
+	return
+}
+
+// clipMP3 cuts on frame boundaries so the clip is never re-encoded
+func (s *Server) clipMP3(input, output string, start, end time.Duration) (err error) {
+	in, err := s.Storage.OpenRange(input, 0, -1)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := s.Storage.Create(output)
+	if err != nil {
+		return
+	}
+	n, err := mp3.Clip(out, in, start, end)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil && n == 0 {
+		err = fmt.Errorf("clip is past the end of the recording")
+	}
+	return
+}
+
+// clipFFmpeg lets ffmpeg stream-copy codecs that do not have a frame parser.
+// ffmpeg needs real files, so the archive is copied out of storage and back.
+func (s *Server) clipFFmpeg(input, output string, start, end time.Duration) (err error) {
+	dir, err := os.MkdirTemp("", "clip")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	tmpIn := filepath.Join(dir, "in"+path.Ext(input))
+	tmpOut := filepath.Join(dir, "out"+path.Ext(output))
+	if err = s.copyOut(input, tmpIn); err != nil {
+		return
+	}
+
+	cmd := exec.Command(ffmpeg.Binary(), "-y", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", start.Seconds()),
+		"-to", fmt.Sprintf("%.3f", end.Seconds()),
+		"-i", tmpIn, "-c", "copy", tmpOut)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Debugf("ffmpeg: %s", out)
+		err = fmt.Errorf("ffmpeg could not clip: %w", err)
+		return
+	}
+	return s.copyIn(tmpOut, output)
+}
+
+// copyOut saves an archive to a local file
+func (s *Server) copyOut(filename, local string) (err error) {
+	r, err := s.Storage.OpenRange(filename, 0, -1)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	f, err := os.Create(local)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(f, r)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return
+}
+
+// copyIn stores a local file as an archive
+func (s *Server) copyIn(local, filename string) (err error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	w, err := s.Storage.Create(filename)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(w, f)
+	if errClose := w.Close(); err == nil {
+		err = errClose
+	}
+	return
+}
+
+// parseTimestamp reads "90", "1:30" or "1:01:30" (with optional fractional
+// seconds) as a duration.
+func parseTimestamp(s string) (d time.Duration, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		err = fmt.Errorf("no time given")
+		return
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		err = fmt.Errorf("could not parse time '%s'", s)
+		return
+	}
+	var seconds float64
+	for _, part := range parts {
+		var v float64
+		v, err = strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			err = fmt.Errorf("could not parse time '%s'", s)
+			return
+		}
+		seconds = seconds*60 + v
+	}
+	d = time.Duration(seconds * float64(time.Second))
+	return
+}
+
+func formatTimestamp(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	sec := int(d.Seconds()) % 60
+	if h > 0 {
+		return fmt.Sprintf("%dh%02dm%02ds", h, m, sec)
+	}
+	return fmt.Sprintf("%dm%02ds", m, sec)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+// testMP3 is n frames of 128 kbps 44.1 kHz MPEG-1 layer III, 26.122 ms each
+func testMP3(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		frame := make([]byte, 417)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"90", 90 * time.Second, true},
+		{" 1:30 ", 90 * time.Second, true},
+		{"1:01:30", time.Hour + 90*time.Second, true},
+		{"0:02.5", 2500 * time.Millisecond, true},
+		{"0", 0, true},
+		{"", 0, false},
+		{"1:2:3:4", 0, false},
+		{"-5", 0, false},
+		{"1:xx", 0, false},
+		{"1::30", 0, false},
+	} {
+		d, err := parseTimestamp(tc.in)
+		if (err == nil) != tc.ok || (tc.ok && d != tc.want) {
+			t.Errorf("%q: %s, %v", tc.in, d, err)
+		}
+	}
+	if got := formatTimestamp(time.Hour + 90*time.Second); got != "1h01m30s" {
+		t.Errorf("formatted %s", got)
+	}
+}
+
+func TestClipArchive(t *testing.T) {
+	s := &Server{Storage: storage.NewLocal(t.TempDir())}
+	// 2.6 seconds of audio
+	if err := storage.WriteAll(s.Storage, "202401021504/show.mp3", testMP3(100)); err != nil {
+		t.Fatal(err)
+	}
+	storage.WriteAll(s.Storage, "202401021504/show.mp3"+transcriptExt,
+		[]byte(`[{"offset":0.5,"id":"1","name":"ann","text":"early"},{"offset":1.25,"id":"2","name":"ann","text":"in the clip"}]`))
+
+	for _, tc := range []struct {
+		name       string
+		start, end time.Duration
+	}{
+		{"end before start", 2 * time.Second, time.Second},
+		{"empty", time.Second, time.Second},
+		{"past the end", time.Minute, 2 * time.Minute},
+	} {
+		if newname, err := s.clipArchive("202401021504/show.mp3", tc.start, tc.end, false); err == nil {
+			t.Errorf("%s: clipped to %s", tc.name, newname)
+		}
+	}
+	if _, err := s.clipArchive("202401021504/missing.mp3", 0, time.Second, false); err == nil {
+		t.Error("clipped a missing archive")
+	}
+
+	// clipping the same part twice keeps both clips
+	for _, want := range []string{"202401021504/show-clip-0m01s-0m02s.mp3", "202401021504/show-clip-0m01s-0m02s-2.mp3"} {
+		newname, err := s.clipArchive("202401021504/show.mp3", time.Second, 2*time.Second, false)
+		if err != nil || newname != want {
+			t.Fatalf("clipped to %s, %v", newname, err)
+		}
+		if info, err := s.Storage.Stat(newname); err != nil || info.Size != 38*417 {
+			t.Errorf("%s: %+v, %v", newname, info, err)
+		}
+		// the clip's chat only has what was said during it, from its start
+		if b, _ := storage.ReadAll(s.Storage, newname+transcriptExt); string(b) != `[{"offset":0.25,"id":"2","name":"ann","text":"in the clip"}]` {
+			t.Errorf("%s chat %s", newname, b)
+		}
+	}
+}
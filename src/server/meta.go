@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+// metaExt is appended to an archive's filename to name its metadata file
+const metaExt = ".json"
+
+// archiveMeta is kept next to every archive so that edits can be checked
+// against the recording's owner.
+type archiveMeta struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	// Owner is the hash of the owner token, the token itself is only ever
+	// given to the broadcaster.
+	Owner string `json:"owner"`
+}
+
+func isMetaFile(fname string) bool {
+	return strings.HasSuffix(fname, metaExt)
+}
+
+func (s *Server) readMeta(filename string) (meta archiveMeta, err error) {
+	b, err := storage.ReadAll(s.Storage, filename+metaExt)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &meta)
+	return
+}
+
+func (s *Server) writeMeta(filename string, meta archiveMeta) (err error) {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	return storage.WriteAll(s.Storage, filename+metaExt, b)
+}
+
+// newToken returns a random secret for the owner of a recording
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// authorized reports whether token may edit the archive at filename. Only
+// the owner token issued when the recording started, or the admin token, will
+// do.
+func (s *Server) authorized(filename, token string) bool {
+	if token == "" {
+		return false
+	}
+	if s.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1 {
+		return true
+	}
+	meta, err := s.readMeta(filename)
+	if err != nil || meta.Owner == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(meta.Owner), []byte(hashToken(token))) == 1
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// longest title kept, in characters
+const maxTitleLength = 200
+
+// nowPlaying is what a stream is playing, as its broadcaster tells it
+type nowPlaying struct {
+	Stream string    `json:"stream"`
+	Title  string    `json:"title"`
+	Since  time.Time `json:"since"`
+}
+
+// cleanTitle keeps a title to a single line of printable text
+func cleanTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, strings.ToValidUTF8(title, ""))
+	title = strings.Join(strings.Fields(title), " ")
+	for utf8.RuneCountInString(title) > maxTitleLength {
+		_, size := utf8.DecodeLastRuneInString(title)
+		title = title[:len(title)-size]
+	}
+	return title
+}
+
+// serveMetadata shows what the stream at /metadata/<stream> is playing.
+// Its broadcaster changes it by POSTing {"title": "..."} with the stream
+// key as "Authorization: Bearer <key>", which is announced in the chat.
+func (s *Server) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/metadata/")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == "POST" {
+		if !s.authenticateChat(name, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+			http.Error(w, "not the stream key", http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "bad metadata", http.StatusBadRequest)
+			return
+		}
+		playing := nowPlaying{Stream: name, Title: cleanTitle(body.Title), Since: time.Now().UTC()}
+		if !s.setPlaying(name, playing) {
+			http.Error(w, "stream is not live", http.StatusNotFound)
+			return
+		}
+		if playing.Title != "" {
+			s.Chat.Announce(name, "now playing: "+playing.Title)
+		}
+		s.notify(EventStreamMetadata, playing)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	playing, ok := s.playing(name)
+	if !ok {
+		http.Error(w, "stream is not live", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playing)
+}
+
+// setPlaying records what the live stream called name is playing. It
+// reports whether the stream is live.
+func (s *Server) setPlaying(name string, playing nowPlaying) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for p, src := range s.sources {
+		if streamName(p) == name {
+			src.playing = playing
+			return true
+		}
+	}
+	return false
+}
+
+// playing is what the live stream called name is playing
+func (s *Server) playing(name string) (playing nowPlaying, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for p, src := range s.sources {
+		if streamName(p) == name {
+			playing = src.playing
+			playing.Stream = name
+			return playing, true
+		}
+	}
+	return
+}
@@ -1,26 +1,25 @@
 package server
 
 import (
+	"context"
 	"embed"
 	"fmt"
-	"io"
+	"html/template"
 	"math/rand"
 	"net/http"
-	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
 	"github.com/dchest/captcha"
-	"github.com/gabriel-vasile/mimetype"
-	"github.com/h2non/filetype"
 	log "github.com/schollz/logger"
 	"github.com/schollz/streammyaudio/src/chat"
-	"github.com/schollz/streammyaudio/src/filecreated"
+	"github.com/schollz/streammyaudio/src/irc"
+	"github.com/schollz/streammyaudio/src/storage"
+	"github.com/schollz/streammyaudio/src/webhook"
 )
 
 //go:embed template
@@ -29,14 +28,56 @@ var templateFiles embed.FS
 //go:embed static/*
 var staticContent embed.FS
 
+// pages escape what they show, as names and titles come from broadcasters
+// and uploaders
+var pages = template.Must(template.ParseFS(templateFiles, "template/*"))
+
 type Server struct {
 	Port   int
 	Folder string
-}
+	// AdminToken may edit any archive, in addition to each archive's owner
+	AdminToken string
+	// MaxUpload is the largest upload in bytes, DefaultMaxUpload if zero
+	MaxUpload int64
+	// Storage keeps the archives, the local Folder is used if it is nil
+	Storage storage.Storage
+	// ResumeGrace is how long a broadcaster whose connection dropped may
+	// resume the stream into the same archive, DefaultResumeGrace if zero
+	ResumeGrace time.Duration
+	// TrimSilence cuts silences longer than this out of archives when
+	// their stream ends, if it is set
+	TrimSilence time.Duration
+	// Conflict is what happens when a second broadcaster starts on a live
+	// stream: ConflictReject (the default), ConflictTakeover or ConflictSuffix
+	Conflict string
+	// FreeChatRooms lets people chat in any room, not only in those of
+	// streams
+	FreeChatRooms bool
+	// ChatRooms are always open, besides the rooms of streams
+	ChatRooms []string
+	// ChatGrace is how long the chat of a stream stays open after the
+	// stream ends, DefaultChatGrace if zero
+	ChatGrace time.Duration
+	// Chat runs the chat rooms, a chat.NewHub() if it is nil. The server
+	// sets its hooks and runs it.
+	Chat *chat.Hub
+	// IRC, if set, relays chat rooms to IRC channels
+	IRC *irc.Bridge
+	// Webhooks, if set, are told when streams start and stop and archives
+	// change. WebhookChat adds every chat message.
+	Webhooks    *webhook.Hooks
+	WebhookChat bool
 
-type stream struct {
-	b    []byte
-	done bool
+	mutex    sync.Mutex
+	channels map[string]map[float64]chan stream
+	sources  map[string]*source
+	// ended is when each stream name last stopped broadcasting
+	ended map[string]time.Time
+	// recent are the archives made less than ChatGrace ago, and when
+	recent map[string]time.Time
+	// making are the archives being recorded or uploaded
+	naming sync.Mutex
+	making map[string]bool
 }
 
 type view struct {
@@ -53,14 +94,57 @@ type view struct {
 
 // Serve will start the server
 func (s *Server) Run() (err error) {
-	go chat.Run()
-
-	tmpl := template.Must(template.ParseFS(templateFiles, "template/*"))
+	switch s.Conflict {
+	case "", ConflictReject, ConflictTakeover, ConflictSuffix:
+	default:
+		err = fmt.Errorf("unknown conflict policy '%s'", s.Conflict)
+		log.Error(err)
+		return
+	}
+	if s.Chat == nil {
+		s.Chat = chat.NewHub()
+	}
+	s.Chat.Authenticate = s.authenticateChat
+	s.Chat.Listeners = s.countListeners
+	s.Chat.OnMessage = func(room string, m chat.Message) {
+		s.recordChat(room, m)
+		if s.WebhookChat && m.Type == chat.TypeMessage {
+			s.notify(EventChatMessage, chatEvent{Room: room, Name: m.Name, Text: m.Text, Host: m.Host, Via: m.Via})
+		}
+	}
+	if s.FreeChatRooms {
+		s.Chat.AllowRoom = nil
+	} else {
+		s.Chat.AllowRoom = s.allowChatRoom
+	}
+	if s.ChatGrace == 0 {
+		s.ChatGrace = DefaultChatGrace
+	}
+	if s.ResumeGrace == 0 {
+		s.ResumeGrace = DefaultResumeGrace
+	}
+	if s.Storage == nil {
+		s.Storage = storage.NewLocal(s.Folder)
+	}
+	s.recent = make(map[string]time.Time)
+	if !s.FreeChatRooms {
+		s.loadRecentArchives()
+	}
+	go s.Chat.Run(context.Background())
+	if s.Webhooks != nil {
+		go s.Webhooks.Run(context.Background())
+	}
+	if s.IRC != nil {
+		go func() {
+			if err := s.IRC.Run(context.Background(), s.Chat); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
 
-	channels := make(map[string]map[float64]chan stream)
-	archived := make(map[string]*os.File)
-	advertisements := make(map[string]bool)
-	mutex := &sync.Mutex{}
+	s.channels = make(map[string]map[float64]chan stream)
+	s.sources = make(map[string]*source)
+	s.ended = make(map[string]time.Time)
 
 	servePage := func(w http.ResponseWriter, r *http.Request, page string, msg string) (err error) {
 		data := view{
@@ -75,11 +159,13 @@ func (s *Server) Run() (err error) {
 		switch page {
 		case "live":
 			adverts := []string{}
-			mutex.Lock()
-			for advert := range advertisements {
-				adverts = append(adverts, strings.TrimPrefix(advert, "/"))
+			s.mutex.Lock()
+			for p, src := range s.sources {
+				if src.advertise {
+					adverts = append(adverts, strings.TrimPrefix(p, "/"))
+				}
 			}
-			mutex.Unlock()
+			s.mutex.Unlock()
 			data.Items = adverts
 		case "archive":
 			active := make(map[string]struct{})
@@ -87,7 +173,7 @@ func (s *Server) Run() (err error) {
 			data.Captcha = captcha.New()
 		}
 		log.Debugf("%s data: %+v", page, data)
-		err = tmpl.ExecuteTemplate(w, page, data)
+		err = pages.ExecuteTemplate(w, page, data)
 		if err != nil {
 			panic(err)
 		}
@@ -110,36 +196,70 @@ func (s *Server) Run() (err error) {
 			w.WriteHeader(http.StatusOK)
 			return
 		} else if r.URL.Path == "/ws" {
-			chat.Serve(w, r)
+			s.Chat.ServeHTTP(w, r)
+			return
+		} else if r.URL.Path == "/upload" {
+			s.handleUpload(w, r)
 			return
 		} else if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/archive") {
-			filename := filepath.Clean(strings.TrimPrefix(r.FormValue("filename"), s.Folder))
-			// This extra join implicitly does a clean and thereby prevents directory traversal
-			filename = path.Join("/", filename)
-			filename = path.Join(s.Folder, filename)
+			filename := storage.Clean(strings.TrimPrefix(r.FormValue("filename"), "archived/"))
 			action := r.FormValue("action")
 			log.Debugf("%s %s", action, filename)
-			if !captcha.VerifyString(r.FormValue("captchaId"), r.FormValue("captchaSolution")) {
-				servePage(w, r, "archive", fmt.Sprintf("Incorrect captcha, could not %s '%s'", action, filename))
+			if action == "report" {
+				// reporting is open to anyone, the captcha keeps it from being automated
+				if !captcha.VerifyString(r.FormValue("captchaId"), r.FormValue("captchaSolution")) {
+					servePage(w, r, "archive", fmt.Sprintf("Incorrect captcha, could not report '%s'", filename))
+					return
+				}
+				log.Infof("reported %s: %s", filename, strings.TrimSpace(r.FormValue("reason")))
+				servePage(w, r, "archive", fmt.Sprintf("Reported '%s', thank you.", filename))
+				return
+			}
+			if !s.authorized(filename, r.FormValue("token")) {
+				servePage(w, r, "archive", fmt.Sprintf("Incorrect owner token, could not %s '%s'", action, filename))
+				return
+			}
+			if s.beingMade(filename) {
+				servePage(w, r, "archive", fmt.Sprintf("'%s' is not finished yet, try again in a moment.", filename))
 				return
 			}
 			msg := ""
 			if action == "remove" {
-				os.Remove(filename)
-				msg = fmt.Sprintf("Removed '%s", filename)
+				if err := s.removeArchive(filename); err != nil {
+					log.Error(err)
+					servePage(w, r, "archive", fmt.Sprintf("Could not remove '%s'.", filename))
+					return
+				}
+				s.notifyArchive(EventArchiveRemoved, filename, "")
+				msg = fmt.Sprintf("Removed '%s'.", filename)
 			} else if action == "rename" {
 				newname := strings.TrimSpace(r.FormValue("newname"))
-				if !strings.HasSuffix(newname, ".mp3") {
-					servePage(w, r, "archive", "Cannot rename suffix '.mp3'.")
+				if ext := path.Ext(filename); !strings.EqualFold(path.Ext(newname), ext) {
+					servePage(w, r, "archive", fmt.Sprintf("Cannot rename suffix '%s'.", ext))
+					return
+				}
+				newname = storage.Clean(newname)
+				if err := s.renameArchive(filename, newname); err != nil {
+					servePage(w, r, "archive", fmt.Sprintf("Could not rename '%s': %s", filename, err))
 					return
 				}
-				// This join with "/" prevents directory traversal with an implicit clean
-				newname = path.Join("/", newname)
-				newname = path.Join(s.Folder, newname)
-				os.Rename(filename, newname)
-				filename = strings.TrimPrefix(filename, "archived/")
-				newname = strings.TrimPrefix(newname, "archived/")
+				s.notifyArchive(EventArchiveRenamed, newname, filename)
 				msg = fmt.Sprintf("Renamed '%s' to '%s'.", filename, newname)
+			} else if action == "clip" {
+				start, errStart := parseTimestamp(r.FormValue("start"))
+				end, errEnd := parseTimestamp(r.FormValue("end"))
+				if errStart != nil || errEnd != nil {
+					servePage(w, r, "archive", "Clip start and end should look like '1:30' or '90'.")
+					return
+				}
+				replace := r.FormValue("replace") != ""
+				newname, errClip := s.clipArchive(filename, start, end, replace)
+				if errClip != nil {
+					servePage(w, r, "archive", fmt.Sprintf("Could not clip '%s': %s", filename, errClip))
+					return
+				}
+				s.notifyArchive(EventArchiveFinalized, newname, "")
+				msg = fmt.Sprintf("Clipped '%s' to '%s'.", filename, newname)
 			}
 			servePage(w, r, "archive", msg)
 			return
@@ -163,177 +283,25 @@ func (s *Server) Run() (err error) {
 			w.Write(p)
 			return
 		} else if !strings.HasSuffix(r.URL.Path, ".mp3") {
-			data := view{
-				Page:      "live",
-				FileNoExt: r.URL.Path[1:],
-				Rand:      fmt.Sprintf("%d", rand.Int31()),
-			}
-			err = tmpl.ExecuteTemplate(w, "chat", data)
-			if err != nil {
-				panic(err)
-			}
-
+			s.serveChat(w, r)
 			return
 		}
 
-		v, ok := r.URL.Query()["stream"]
-		doStream := ok && v[0] == "true"
-
-		v, ok = r.URL.Query()["archive"]
-		doArchive := ok && v[0] == "true"
-
-		if doArchive && r.Method == "POST" {
-			if _, ok := archived[r.URL.Path]; !ok {
-				folderName := path.Join(s.Folder, time.Now().Format("200601021504"))
-				os.MkdirAll(folderName, os.ModePerm)
-				archived[r.URL.Path], err = os.Create(path.Join(folderName, strings.TrimPrefix(r.URL.Path, "/")))
-				if err != nil {
-					log.Error(err)
-				}
-			}
-			defer func() {
-				mutex.Lock()
-				if _, ok := archived[r.URL.Path]; ok {
-					log.Debugf("closed archive for %s", r.URL.Path)
-					archived[r.URL.Path].Close()
-					delete(archived, r.URL.Path)
-				}
-				mutex.Unlock()
-			}()
-		}
-
-		v, ok = r.URL.Query()["advertise"]
-		log.Debugf("advertise: %+v", v)
-		if ok && v[0] == "true" && doStream {
-			mutex.Lock()
-			advertisements[r.URL.Path] = true
-			mutex.Unlock()
-			defer func() {
-				mutex.Lock()
-				delete(advertisements, r.URL.Path)
-				mutex.Unlock()
-			}()
-		}
-
-		mutex.Lock()
-		if _, ok := channels[r.URL.Path]; !ok {
-			channels[r.URL.Path] = make(map[float64]chan stream)
-		}
-		mutex.Unlock()
-
 		if r.Method == "GET" {
-			id := rand.Float64()
-			mutex.Lock()
-			channels[r.URL.Path][id] = make(chan stream, 30)
-			channel := channels[r.URL.Path][id]
-			log.Debugf("added listener %f", id)
-			mutex.Unlock()
-
-			w.Header().Set("Connection", "keep-alive")
-			w.Header().Set("Pragma", "no-cache")
-			w.Header().Set("Cache-Control", "no-cache, no-store")
-
-			mimetyped := false
-			canceled := false
-			for {
-				select {
-				case s := <-channel:
-					if s.done {
-						canceled = true
-					} else {
-						if !mimetyped {
-							mimetyped = true
-							mimetype := mimetype.Detect(s.b).String()
-							if mimetype == "application/octet-stream" {
-								ext := strings.TrimPrefix(filepath.Ext(r.URL.Path), ".")
-								log.Debug("checking extension %s", ext)
-								mimetype = filetype.GetType(ext).MIME.Value
-							}
-							w.Header().Set("Content-Type", mimetype)
-							log.Debugf("serving as Content-Type: '%s'", mimetype)
-						}
-						w.Write(s.b)
-						w.(http.Flusher).Flush()
-					}
-				case <-r.Context().Done():
-					log.Debug("consumer canceled")
-					canceled = true
-				}
-				if canceled {
-					break
-				}
-			}
-
-			mutex.Lock()
-			delete(channels[r.URL.Path], id)
-			log.Debugf("removed listener %f", id)
-			mutex.Unlock()
-			close(channel)
+			s.handleListener(w, r)
 		} else if r.Method == "POST" {
-			buffer := make([]byte, 2048)
-			cancel := true
-			isdone := false
-			lifetime := 0
-			for {
-				if !doStream {
-					select {
-					case <-r.Context().Done():
-						isdone = true
-					default:
-					}
-					if isdone {
-						log.Debug("is done")
-						break
-					}
-					mutex.Lock()
-					numListeners := len(channels[r.URL.Path])
-					mutex.Unlock()
-					if numListeners == 0 {
-						time.Sleep(1 * time.Second)
-						lifetime++
-						if lifetime > 600 {
-							isdone = true
-						}
-						continue
-					}
-				}
-				n, err := r.Body.Read(buffer)
-				if err != nil {
-					log.Debugf("err: %s", err)
-					if err == io.ErrUnexpectedEOF {
-						cancel = false
-					}
-					break
-				}
-				if doArchive {
-					mutex.Lock()
-					archived[r.URL.Path].Write(buffer[:n])
-					mutex.Unlock()
-				}
-				mutex.Lock()
-				channels_current := channels[r.URL.Path]
-				mutex.Unlock()
-				for _, c := range channels_current {
-					var b2 = make([]byte, n)
-					copy(b2, buffer[:n])
-					c <- stream{b: b2}
-				}
-			}
-			if cancel {
-				mutex.Lock()
-				channels_current := channels[r.URL.Path]
-				mutex.Unlock()
-				for _, c := range channels_current {
-					c <- stream{done: true}
-				}
-			}
+			s.handleSource(w, r)
 		} else {
 			w.WriteHeader(http.StatusOK)
 		}
 	}
 
 	log.Infof("running on port %d", s.Port)
-	http.Handle("/archived/", http.FileServer(http.Dir(".")))
+	http.HandleFunc("/archived/", s.serveArchived)
+	http.HandleFunc("/presence/", s.servePresence)
+	http.HandleFunc("/metadata/", s.serveMetadata)
+	http.HandleFunc("/silence/", s.serveSilence)
+	http.HandleFunc("/webhooks", s.serveWebhooks)
 	http.Handle("/captcha/", captcha.Server(captcha.StdWidth, captcha.StdHeight))
 	http.HandleFunc("/", handler)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", s.Port), nil)
@@ -343,36 +311,53 @@ func (s *Server) Run() (err error) {
 	return
 }
 
+// serveChat serves the page of a stream, with its player and chat room
+func (s *Server) serveChat(w http.ResponseWriter, r *http.Request) {
+	data := view{
+		Page:      "live",
+		FileNoExt: r.URL.Path[1:],
+		Rand:      fmt.Sprintf("%d", rand.Int31()),
+	}
+	if err := pages.ExecuteTemplate(w, "chat", data); err != nil {
+		panic(err)
+	}
+}
+
 type ArchivedFile struct {
 	Filename     string
 	FullFilename string
 	Created      time.Time
+	// Transcript is where the chat recorded with the archive is, if any
+	Transcript string
 }
 
 func (s *Server) listArchived(active map[string]struct{}) (afiles []ArchivedFile) {
-	fnames := []string{}
-	err := filepath.Walk(s.Folder,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				fnames = append(fnames, path)
-			}
-			return nil
-		})
+	infos, err := s.Storage.List()
 	if err != nil {
+		log.Error(err)
 		return
 	}
-	for _, fname := range fnames {
-		_, onlyfname := path.Split(fname)
-		created := filecreated.FileCreated(fname)
+	transcripts := make(map[string]bool)
+	for _, info := range infos {
+		if isTranscriptFile(info.Name) {
+			transcripts[strings.TrimSuffix(info.Name, transcriptExt)] = true
+		}
+	}
+	for _, info := range infos {
+		if isMetaFile(info.Name) || s.beingMade(info.Name) {
+			continue
+		}
+		_, onlyfname := path.Split(info.Name)
 		if _, ok := active[onlyfname]; !ok {
-			afiles = append(afiles, ArchivedFile{
+			afile := ArchivedFile{
 				Filename:     onlyfname,
-				FullFilename: fname,
-				Created:      created,
-			})
+				FullFilename: path.Join("archived", info.Name),
+				Created:      info.Modified,
+			}
+			if transcripts[info.Name] {
+				afile.Transcript = afile.FullFilename + transcriptExt
+			}
+			afiles = append(afiles, afile)
 		}
 	}
 
@@ -382,3 +367,24 @@ func (s *Server) listArchived(active map[string]struct{}) (afiles []ArchivedFile
 
 	return
 }
+
+// serveArchived plays back an archive, with range requests so listeners can
+// seek
+func (s *Server) serveArchived(w http.ResponseWriter, r *http.Request) {
+	filename := storage.Clean(strings.TrimPrefix(r.URL.Path, "/archived/"))
+	if isMetaFile(filename) && !isTranscriptFile(filename) {
+		http.NotFound(w, r)
+		return
+	}
+	rs, info, err := storage.NewReadSeeker(s.Storage, filename)
+	if err == storage.ErrNotExist {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Error(err)
+		http.Error(w, "could not open archive", http.StatusInternalServerError)
+		return
+	}
+	defer rs.Close()
+	http.ServeContent(w, r, filename, info.Modified, rs)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPagesEscape(t *testing.T) {
+	evil := `<script>alert(1)</script>`
+	for _, page := range []string{"sya", "live", "archive", "chat", "download"} {
+		var b bytes.Buffer
+		err := pages.ExecuteTemplate(&b, page, view{
+			Page:      page,
+			Message:   "Renamed to '" + evil + "'.",
+			FileNoExt: evil,
+			Items:     []string{evil + ".mp3"},
+			Archived: []ArchivedFile{{
+				Filename:     evil + ".mp3",
+				FullFilename: "archived/1/" + evil + ".mp3",
+				Created:      time.Now(),
+			}},
+			StripMP3: func(s string) string { return strings.TrimSuffix(s, ".mp3") },
+		})
+		if err != nil {
+			t.Errorf("%s: %v", page, err)
+		} else if strings.Contains(b.String(), evil) {
+			t.Errorf("%s shows a name as HTML", page)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/schollz/streammyaudio/src/ffmpeg"
+	"github.com/schollz/streammyaudio/src/mp3"
+)
+
+const (
+	// audio quieter than this is silence
+	silenceNoise = "-50dB"
+	// how much of a silence is kept on each side of a cut, so the audio
+	// around it does not run together
+	silencePadding = time.Second
+)
+
+// silenceNotice is a broadcaster saying their stream has gone quiet
+type silenceNotice struct {
+	Stream  string  `json:"stream"`
+	Seconds float64 `json:"seconds"`
+}
+
+// serveSilence takes a POST of {"seconds": ...} from the broadcaster of the
+// stream at /silence/<stream>, with the stream key as "Authorization:
+// Bearer <key>", when nothing has been heard for that long. The chat is
+// told, in case the broadcaster is not watching.
+func (s *Server) serveSilence(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/silence/")
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authenticateChat(name, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		http.Error(w, "not the stream key", http.StatusUnauthorized)
+		return
+	}
+	notice := silenceNotice{Stream: name}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&notice); err != nil || notice.Seconds <= 0 {
+		http.Error(w, "bad silence notice", http.StatusBadRequest)
+		return
+	}
+	quiet := time.Duration(notice.Seconds * float64(time.Second)).Round(time.Second)
+	if !s.live(name) {
+		http.Error(w, "stream is not live", http.StatusNotFound)
+		return
+	}
+	if !s.Chat.Announce(name, fmt.Sprintf("the stream has been silent for %s", quiet)) {
+		log.Debugf("could not tell the chat of %s about the silence", name)
+	}
+	s.notify(EventStreamSilence, notice)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trimSilence cuts the silences longer than TrimSilence out of the archive
+// of src. Chat sent during a cut moves to where the cut is, and chat after
+// it moves up with the audio.
+func (s *Server) trimSilence(src *source) (err error) {
+	filename := src.archiveName
+	if !strings.EqualFold(path.Ext(filename), ".mp3") {
+		return
+	}
+	dir, err := os.MkdirTemp("", "silence")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "archive.mp3")
+	if err = s.copyOut(filename, local); err != nil {
+		return
+	}
+	cmd := exec.Command(ffmpeg.Binary(), "-hide_banner", "-nostats", "-i", local,
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%.3f", silenceNoise, s.TrimSilence.Seconds()),
+		"-f", "null", "-")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Debugf("ffmpeg: %s", out)
+		err = fmt.Errorf("ffmpeg could not look for silence: %w", err)
+		return
+	}
+	cuts := silenceCuts(string(out))
+	if len(cuts) == 0 {
+		return
+	}
+
+	in, err := os.Open(local)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	output := filename + ".trim.mp3"
+	w, err := s.Storage.Create(output)
+	if err != nil {
+		return
+	}
+	_, err = mp3.Cut(w, in, cuts)
+	if errClose := w.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = s.Storage.Rename(output, filename)
+	}
+	if err != nil {
+		s.Storage.Delete(output)
+		return
+	}
+
+	var removed time.Duration
+	for _, cut := range cuts {
+		if cut.End > 0 {
+			removed += cut.End - cut.Start
+		}
+	}
+	log.Infof("cut %s of silence from %s", removed, filename)
+	s.mutex.Lock()
+	for i := range src.transcript {
+		src.transcript[i].Offset = shiftOffset(src.transcript[i].Offset, cuts)
+	}
+	s.mutex.Unlock()
+	return
+}
+
+// silenceCuts reads the silences silencedetect found, like
+//
+//	[silencedetect @ 0x55] silence_start: 12.5
+//	[silencedetect @ 0x55] silence_end: 70.3 | silence_duration: 57.8
+//
+// and returns what to cut out of each. A silence that never ends goes on
+// to the end.
+func silenceCuts(output string) (cuts []mp3.Span) {
+	start := -1.0
+	for _, line := range strings.Split(output, "\n") {
+		if _, value, ok := strings.Cut(line, "silence_start:"); ok {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				start = max(v, 0)
+			}
+			continue
+		}
+		_, value, ok := strings.Cut(line, "silence_end:")
+		if !ok || start < 0 {
+			continue
+		}
+		value, _, _ = strings.Cut(value, "|")
+		end, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		cut := mp3.Span{Start: seconds(start) + silencePadding, End: seconds(end) - silencePadding}
+		if cut.End > cut.Start {
+			cuts = append(cuts, cut)
+		}
+		start = -1
+	}
+	if start >= 0 {
+		cuts = append(cuts, mp3.Span{Start: seconds(start) + silencePadding})
+	}
+	return
+}
+
+// shiftOffset is where offset, in seconds, ends up once the cuts are made
+func shiftOffset(offset float64, cuts []mp3.Span) float64 {
+	at := seconds(offset)
+	var removed time.Duration
+	for _, cut := range cuts {
+		if at < cut.Start {
+			break
+		}
+		if cut.End == 0 || at < cut.End {
+			// sent during the silence
+			at = cut.Start
+			break
+		}
+		removed += cut.End - cut.Start
+	}
+	return (at - removed).Seconds()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/streammyaudio/src/chat"
+	"github.com/schollz/streammyaudio/src/mp3"
+)
+
+func TestSilenceCuts(t *testing.T) {
+	s := time.Second
+	for _, tc := range []struct {
+		name   string
+		output string
+		want   []mp3.Span
+	}{
+		{"none", "size=N/A time=00:01:00.00\n", nil},
+		{
+			"padded on both sides",
+			"[silencedetect @ 0x55] silence_start: 12.5\n" +
+				"[silencedetect @ 0x55] silence_end: 70.5 | silence_duration: 58\n",
+			[]mp3.Span{{Start: 13500 * time.Millisecond, End: 69500 * time.Millisecond}},
+		},
+		{
+			"from the start, and to the end",
+			"[silencedetect @ 0x55] silence_start: -0.01\n" +
+				"[silencedetect @ 0x55] silence_end: 30 | silence_duration: 30\n" +
+				"[silencedetect @ 0x55] silence_start: 100\n",
+			[]mp3.Span{{Start: s, End: 29 * s}, {Start: 101 * s}},
+		},
+		{
+			"too short once padded",
+			"[silencedetect @ 0x55] silence_start: 10\n" +
+				"[silencedetect @ 0x55] silence_end: 11.5 | silence_duration: 1.5\n" +
+				"[silencedetect @ 0x55] silence_start: 20\n" +
+				"[silencedetect @ 0x55] silence_end: 25 | silence_duration: 5\n",
+			[]mp3.Span{{Start: 21 * s, End: 24 * s}},
+		},
+		{"end without a start", "[silencedetect @ 0x55] silence_end: 25 | silence_duration: 5\n", nil},
+	} {
+		if got := silenceCuts(tc.output); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%q: %v, not %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestShiftOffset(t *testing.T) {
+	s := time.Second
+	cuts := []mp3.Span{{Start: 10 * s, End: 20 * s}, {Start: 30 * s, End: 35 * s}, {Start: 60 * s}}
+	for _, tc := range []struct {
+		offset, want float64
+	}{
+		{5, 5},
+		{10, 10},
+		// sent during a silence, shown where it was cut
+		{15, 10},
+		{20, 10},
+		{25, 15},
+		{31.5, 20},
+		{40, 25},
+		// the last cut goes to the end
+		{90, 45},
+	} {
+		if got := shiftOffset(tc.offset, cuts); got != tc.want {
+			t.Errorf("%g: %g, not %g", tc.offset, got, tc.want)
+		}
+	}
+}
+
+func TestServeSilence(t *testing.T) {
+	s := &Server{
+		Chat:       chat.NewHub(),
+		AdminToken: "admin",
+		sources:    map[string]*source{"/show.mp3": {token: "owner"}},
+	}
+	// the chat being gone does not make the stream any less live
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+	s.Chat.Run(ctx)
+
+	for _, tc := range []struct {
+		name, stream, key, body string
+		want                    int
+	}{
+		{"live", "show", "owner", `{"seconds": 30}`, http.StatusNoContent},
+		{"not live", "other", "admin", `{"seconds": 30}`, http.StatusNotFound},
+		{"wrong key", "show", "guess", `{"seconds": 30}`, http.StatusUnauthorized},
+		{"no seconds", "show", "owner", `{}`, http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("POST", "/silence/"+tc.stream, strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer "+tc.key)
+		w := httptest.NewRecorder()
+		s.serveSilence(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%q: %d, not %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/h2non/filetype"
+	log "github.com/schollz/logger"
+)
+
+// Policies for a second broadcaster on a stream name that is already live
+const (
+	// ConflictReject turns the second broadcaster away
+	ConflictReject = "reject"
+	// ConflictTakeover disconnects the first broadcaster
+	ConflictTakeover = "takeover"
+	// ConflictSuffix moves the second broadcaster to a free name
+	ConflictSuffix = "suffix"
+)
+
+// DefaultResumeGrace is how long a broadcaster whose connection dropped may
+// resume the stream, unless the server sets ResumeGrace
+const DefaultResumeGrace = 2 * time.Minute
+
+type stream struct {
+	b []byte
+}
+
+// source is a broadcaster POSTing audio to a stream
+type source struct {
+	token string
+	// hostKey only makes its holder the host of the chat, so it can be
+	// shared in a link without handing over the archive
+	hostKey   string
+	advertise bool
+	archive   io.WriteCloser
+	// started is when the archive was created, transcript is the chat
+	// since then. Both are guarded by the server's mutex.
+	started     time.Time
+	transcript  []transcriptEntry
+	archiveName string
+	event       streamEvent
+	// received counts the bytes read from the broadcaster, across resumes
+	received int64
+	// playing is the broadcaster's now playing, guarded by the server's mutex
+	playing nowPlaying
+
+	// suspended is set while the broadcaster's connection is gone and it
+	// may still resume, until expire fires. Guarded by the server's mutex.
+	suspended bool
+	expire    *time.Timer
+
+	// kicked is closed when another broadcaster takes over the stream
+	kicked   chan struct{}
+	kickOnce sync.Once
+	// interrupt unblocks a pending read of the request body
+	interrupt func()
+}
+
+func (src *source) kick() {
+	src.kickOnce.Do(func() {
+		close(src.kicked)
+		src.interrupt()
+	})
+}
+
+type errConflict struct {
+	name       string
+	suggestion string
+}
+
+func (e errConflict) Error() string {
+	return fmt.Sprintf("'%s' is already live, try '%s'", e.name, e.suggestion)
+}
+
+// claimSource registers src as the broadcaster for the stream at p, applying
+// the conflict policy if someone is already broadcasting there. It returns
+// the path src was given.
+func (s *Server) claimSource(p string, src *source) (claimed string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, ok := s.sources[p]
+	if !ok {
+		s.sources[p] = src
+		return p, nil
+	}
+
+	switch s.Conflict {
+	case ConflictTakeover:
+		log.Infof("new source took over %s", p)
+		if existing.suspended {
+			existing.suspended = false
+			existing.expire.Stop()
+			go s.finishSource(existing)
+		} else {
+			existing.kick()
+		}
+	case ConflictSuffix:
+		p = s.freeName(p)
+		log.Infof("moved new source to %s", p)
+	default:
+		err = errConflict{
+			name:       streamName(p),
+			suggestion: streamName(s.freeName(p)),
+		}
+		return
+	}
+	s.sources[p] = src
+	return p, nil
+}
+
+// releaseSource forgets src, unless it has already been replaced. It
+// reports whether src was still the stream's broadcaster.
+func (s *Server) releaseSource(p string, src *source) (current bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current = s.sources[p] == src
+	if current {
+		delete(s.sources, p)
+		s.ended[streamName(p)] = time.Now()
+	}
+	return
+}
+
+// resumeSource hands the source at p back to its broadcaster, who proves it
+// is theirs with the owner token. If the old connection has not noticed it
+// is gone yet, it is interrupted first.
+func (s *Server) resumeSource(p, token string, interrupt func()) (src *source) {
+	if token == "" {
+		return
+	}
+	for i := 0; i < 50; i++ {
+		s.mutex.Lock()
+		existing, ok := s.sources[p]
+		if !ok || subtle.ConstantTimeCompare([]byte(existing.token), []byte(token)) != 1 {
+			s.mutex.Unlock()
+			return
+		}
+		if existing.suspended {
+			existing.suspended = false
+			existing.expire.Stop()
+			existing.interrupt = interrupt
+			s.mutex.Unlock()
+			return existing
+		}
+		if i == 0 {
+			existing.interrupt()
+		}
+		s.mutex.Unlock()
+		time.Sleep(100 * time.Millisecond)
+	}
+	return
+}
+
+// suspendSource keeps src as the stream's broadcaster for a while after its
+// connection dropped, so it can resume. It reports whether src was still
+// the broadcaster.
+func (s *Server) suspendSource(p string, src *source) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sources[p] != src {
+		return false
+	}
+	src.suspended = true
+	src.expire = time.AfterFunc(s.ResumeGrace, func() {
+		s.mutex.Lock()
+		expired := src.suspended && s.sources[p] == src
+		src.suspended = false
+		s.mutex.Unlock()
+		if !expired {
+			return
+		}
+		log.Debugf("%s did not resume", p)
+		if s.releaseSource(p, src) {
+			s.endListeners(p)
+		}
+		s.finishSource(src)
+	})
+	return true
+}
+
+// finishSource ends a broadcast, finalizing its archive
+func (s *Server) finishSource(src *source) {
+	s.notify(EventStreamStop, src.event)
+	if src.archive == nil {
+		return
+	}
+	if err := src.archive.Close(); err != nil {
+		log.Error(err)
+	}
+	if s.TrimSilence > 0 {
+		// going through the whole recording takes a while, the broadcaster
+		// should not wait for it
+		go s.finishArchive(src)
+		return
+	}
+	s.finishArchive(src)
+}
+
+// finishArchive trims the silences out of the archive of a broadcast if the
+// server does that, and saves its chat. Until then the archive is not
+// listed and cannot be edited, which would undo the trim or part it from
+// its chat.
+func (s *Server) finishArchive(src *source) {
+	if s.TrimSilence > 0 {
+		if err := s.trimSilence(src); err != nil {
+			log.Errorf("%s: %s", src.archiveName, err)
+		}
+	}
+	s.saveTranscript(src.archiveName, src)
+	s.madeArchive(src.archiveName)
+	s.addRecent(src.archiveName)
+	s.notifyArchive(EventArchiveFinalized, src.archiveName, "")
+}
+
+// freeName finds the first "name-N.ext" that nobody is broadcasting on.
+// The mutex must be held.
+func (s *Server) freeName(p string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, ok := s.sources[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+// streamName is the name people type for the stream at p
+func streamName(p string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(p, "/"), path.Ext(p))
+}
+
+// newArchiveName picks a storage name for a new recording of the stream at
+// p that does not overwrite an existing archive. The name is kept for the
+// recording until madeArchive, as storage may only have it once it is
+// complete.
+func (s *Server) newArchiveName(p string) string {
+	s.naming.Lock()
+	defer s.naming.Unlock()
+	if s.making == nil {
+		s.making = make(map[string]bool)
+	}
+	folder := time.Now().Format("200601021504")
+	filename := path.Join(folder, strings.TrimPrefix(p, "/"))
+	ext := path.Ext(filename)
+	for i := 2; ; i++ {
+		if _, err := s.Storage.Stat(filename); err != nil && !s.making[filename] {
+			s.making[filename] = true
+			return filename
+		}
+		filename = path.Join(folder, fmt.Sprintf("%s-%d%s", streamName(p), i, ext))
+	}
+}
+
+// broadcast sends b to everyone listening to the stream at p. A listener
+// that cannot keep up is cut off, so it never holds up the broadcaster.
+// Channels are only sent on and closed with the mutex held.
+func (s *Server) broadcast(p string, b []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, c := range s.channels[p] {
+		select {
+		case c <- stream{b: b}:
+		default:
+			log.Debugf("listener %f fell behind", id)
+			delete(s.channels[p], id)
+			close(c)
+		}
+	}
+}
+
+// endListeners lets everyone listening to the stream at p know it ended,
+// once they have what was sent to them
+func (s *Server) endListeners(p string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.channels[p] {
+		close(c)
+	}
+	delete(s.channels, p)
+}
+
+// handleListener streams audio from the broadcaster to a listener
+func (s *Server) handleListener(w http.ResponseWriter, r *http.Request) {
+	id := rand.Float64()
+	channel := make(chan stream, 30)
+	s.mutex.Lock()
+	if _, ok := s.channels[r.URL.Path]; !ok {
+		s.channels[r.URL.Path] = make(map[float64]chan stream)
+	}
+	s.channels[r.URL.Path][id] = channel
+	log.Debugf("added listener %f", id)
+	s.mutex.Unlock()
+
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+
+	mimetyped := false
+	canceled := false
+	for {
+		select {
+		case s, ok := <-channel:
+			if !ok {
+				canceled = true
+			} else {
+				if !mimetyped {
+					mimetyped = true
+					mimetype := mimetype.Detect(s.b).String()
+					if mimetype == "application/octet-stream" {
+						ext := strings.TrimPrefix(filepath.Ext(r.URL.Path), ".")
+						log.Debugf("checking extension %s", ext)
+						mimetype = filetype.GetType(ext).MIME.Value
+					}
+					w.Header().Set("Content-Type", mimetype)
+					log.Debugf("serving as Content-Type: '%s'", mimetype)
+				}
+				w.Write(s.b)
+				w.(http.Flusher).Flush()
+			}
+		case <-r.Context().Done():
+			log.Debug("consumer canceled")
+			canceled = true
+		}
+		if canceled {
+			break
+		}
+	}
+
+	s.mutex.Lock()
+	delete(s.channels[r.URL.Path], id)
+	if len(s.channels[r.URL.Path]) == 0 {
+		delete(s.channels, r.URL.Path)
+	}
+	log.Debugf("removed listener %f", id)
+	s.mutex.Unlock()
+}
+
+// handleSource reads the audio a broadcaster POSTs and fans it out to the
+// stream's listeners, and to the archive if one was asked for. A
+// broadcaster whose connection dropped may resume by passing its owner
+// token as "resume", the audio then goes on into the same archive.
+func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	doStream := query.Get("stream") == "true"
+	doArchive := query.Get("archive") == "true"
+
+	rc := http.NewResponseController(w)
+	interrupt := func() {
+		rc.SetReadDeadline(time.Now())
+	}
+	name := r.URL.Path
+	src := s.resumeSource(name, query.Get("resume"), interrupt)
+	resumed := src != nil
+	if resumed {
+		log.Infof("resumed %s after %d bytes", name, src.received)
+		w.Header().Set("X-Received", fmt.Sprint(src.received))
+	} else {
+		src = &source{
+			// every broadcast gets a secret that lets its owner edit the archive later
+			token:     newToken(),
+			hostKey:   newToken(),
+			advertise: doStream && query.Get("advertise") == "true",
+			kicked:    make(chan struct{}),
+			interrupt: interrupt,
+		}
+		var err error
+		name, err = s.claimSource(name, src)
+		if err != nil {
+			log.Infof("rejected source: %s", err)
+			// the audio that follows is not read, closing the connection
+			// answers right away instead of after draining some of it
+			w.Header().Set("Connection", "close")
+			w.Header().Set("X-Suggested-Name", err.(errConflict).suggestion)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	if doArchive && !resumed {
+		archiveName := s.newArchiveName(name)
+		archive, err := s.Storage.Create(archiveName)
+		if err != nil {
+			log.Error(err)
+			s.madeArchive(archiveName)
+		} else {
+			s.mutex.Lock()
+			src.archive = archive
+			src.archiveName = archiveName
+			src.started = time.Now()
+			s.mutex.Unlock()
+			err = s.writeMeta(archiveName, archiveMeta{
+				Name:    path.Base(archiveName),
+				Created: time.Now(),
+				Owner:   hashToken(src.token),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	// hand the owner token back right away, while the body is still being
+	// read. Clients that expect "100 Continue" would take the early response
+	// as a refusal of the body, so reading first sends it to them.
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		r.Body.Read(nil)
+	}
+	w.Header().Set("X-Owner-Token", src.token)
+	w.Header().Set("X-Host-Key", src.hostKey)
+	w.Header().Set("X-Stream-Name", streamName(name))
+	if err := rc.EnableFullDuplex(); err != nil {
+		log.Debugf("full duplex: %s", err)
+	}
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	if !resumed {
+		src.event = streamEvent{
+			Stream:    streamName(name),
+			Advertise: src.advertise,
+			Archive:   src.archive != nil,
+		}
+		s.notify(EventStreamStart, src.event)
+		if src.advertise {
+			s.notify(EventStreamAdvertise, src.event)
+		}
+	}
+
+	buffer := make([]byte, 2048)
+	cancel := true
+	dropped := false
+	isdone := false
+	lifetime := 0
+	for {
+		if !doStream {
+			select {
+			case <-r.Context().Done():
+				isdone = true
+			case <-src.kicked:
+				isdone = true
+			default:
+			}
+			if isdone {
+				log.Debug("is done")
+				break
+			}
+			s.mutex.Lock()
+			numListeners := len(s.channels[name])
+			s.mutex.Unlock()
+			if numListeners == 0 {
+				time.Sleep(1 * time.Second)
+				lifetime++
+				if lifetime > 600 {
+					isdone = true
+				}
+				continue
+			}
+		}
+		n, err := r.Body.Read(buffer)
+		if n > 0 {
+			src.received += int64(n)
+			if src.archive != nil {
+				src.archive.Write(buffer[:n])
+			}
+			var b2 = make([]byte, n)
+			copy(b2, buffer[:n])
+			s.broadcast(name, b2)
+		}
+		if err != nil {
+			log.Debugf("err: %s", err)
+			if err == io.ErrUnexpectedEOF {
+				cancel = false
+			}
+			dropped = err != io.EOF
+			break
+		}
+	}
+
+	select {
+	case <-src.kicked:
+		fmt.Fprintln(w, "another broadcaster took over the stream")
+	default:
+		// listeners keep waiting while the broadcaster may come back
+		if dropped && s.suspendSource(name, src) {
+			log.Infof("%s dropped, waiting %s for it to resume", name, s.ResumeGrace)
+			return
+		}
+	}
+
+	// if another source took over, its listeners should keep listening
+	if s.releaseSource(name, src) && cancel {
+		s.endListeners(name)
+	}
+	s.finishSource(src)
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+// newStreamServer serves sources and listeners like Run does
+func newStreamServer(t *testing.T, s *Server) *httptest.Server {
+	if s.Storage == nil {
+		s.Storage = storage.NewLocal(t.TempDir())
+	}
+	if s.ResumeGrace == 0 {
+		s.ResumeGrace = time.Minute
+	}
+	s.channels = make(map[string]map[float64]chan stream)
+	s.sources = make(map[string]*source)
+	s.ended = make(map[string]time.Time)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			s.handleListener(w, r)
+		} else {
+			s.handleSource(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// broadcaster is a source whose audio the test writes
+type broadcaster struct {
+	*io.PipeWriter
+	resp *http.Response
+}
+
+// broadcast connects a broadcaster to the stream at p, query is added to
+// the request
+func broadcast(t *testing.T, ts *httptest.Server, p, query string) (b broadcaster) {
+	t.Helper()
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest("POST", ts.URL+p+"?stream=true&"+query, pr)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pw.Close()
+		resp.Body.Close()
+	})
+	return broadcaster{pw, resp}
+}
+
+// drop cuts the broadcaster off without ending the stream
+func (b broadcaster) drop() {
+	b.CloseWithError(errors.New("connection dropped"))
+	b.resp.Body.Close()
+}
+
+// listen starts a listener on the stream at p. The response comes with the
+// first audio, so it is read from the returned channel.
+func listen(t *testing.T, s *Server, ts *httptest.Server, p string) (listener chan io.ReadCloser) {
+	t.Helper()
+	listener = make(chan io.ReadCloser, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + p)
+		if err != nil {
+			close(listener)
+			return
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		listener <- resp.Body
+	}()
+	waitFor(t, func() bool { return s.countListeners(streamName(p)) > 0 })
+	return
+}
+
+// hear reads what a listener gets next
+func hear(t *testing.T, body io.Reader, want string) {
+	t.Helper()
+	got := make(chan string, 1)
+	go func() {
+		b := make([]byte, len(want))
+		n, _ := io.ReadFull(body, b)
+		got <- string(b[:n])
+	}()
+	select {
+	case g := <-got:
+		if g != want {
+			t.Errorf("heard %q, not %q", g, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("did not hear %q", want)
+	}
+}
+
+func waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if ok() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
+
+func TestConflict(t *testing.T) {
+	for _, policy := range []string{ConflictReject, ConflictTakeover, ConflictSuffix} {
+		t.Run(policy, func(t *testing.T) {
+			s := &Server{Conflict: policy}
+			ts := newStreamServer(t, s)
+			first := broadcast(t, ts, "/show.mp3", "")
+			listener := listen(t, s, ts, "/show.mp3")
+			first.Write([]byte("first "))
+			body := <-listener
+			hear(t, body, "first ")
+
+			second := broadcast(t, ts, "/show.mp3", "")
+			switch policy {
+			case ConflictReject:
+				if second.resp.StatusCode != http.StatusConflict || second.resp.Header.Get("X-Suggested-Name") != "show-2" {
+					t.Fatalf("second got %s, suggested %q", second.resp.Status, second.resp.Header.Get("X-Suggested-Name"))
+				}
+				// the first goes on undisturbed
+				first.Write([]byte("still first"))
+				hear(t, body, "still first")
+			case ConflictTakeover:
+				if second.resp.StatusCode != http.StatusOK || second.resp.Header.Get("X-Stream-Name") != "show" {
+					t.Fatalf("second got %s as %q", second.resp.Status, second.resp.Header.Get("X-Stream-Name"))
+				}
+				msg, _ := io.ReadAll(first.resp.Body)
+				if !strings.Contains(string(msg), "took over") {
+					t.Errorf("first was told %q", msg)
+				}
+				// the listener stays and hears the new broadcaster
+				second.Write([]byte("second"))
+				hear(t, body, "second")
+			case ConflictSuffix:
+				if second.resp.StatusCode != http.StatusOK || second.resp.Header.Get("X-Stream-Name") != "show-2" {
+					t.Fatalf("second got %s as %q", second.resp.Status, second.resp.Header.Get("X-Stream-Name"))
+				}
+				other := listen(t, s, ts, "/show-2.mp3")
+				second.Write([]byte("second"))
+				hear(t, <-other, "second")
+				first.Write([]byte("still first"))
+				hear(t, body, "still first")
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := &Server{ResumeGrace: 300 * time.Millisecond}
+	ts := newStreamServer(t, s)
+	archives := func() (names []string) {
+		infos, _ := s.Storage.List()
+		for _, info := range infos {
+			if !isMetaFile(info.Name) {
+				names = append(names, info.Name)
+			}
+		}
+		return
+	}
+
+	first := broadcast(t, ts, "/show.mp3", "archive=true")
+	token := first.resp.Header.Get("X-Owner-Token")
+	listener := listen(t, s, ts, "/show.mp3")
+	first.Write([]byte("one "))
+	body := <-listener
+	hear(t, body, "one ")
+	first.drop()
+	waitFor(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.sources["/show.mp3"] != nil && s.sources["/show.mp3"].suspended
+	})
+
+	// only the owner may resume, anyone else finds the name taken
+	other := broadcast(t, ts, "/show.mp3", "resume=guess")
+	if other.resp.StatusCode != http.StatusConflict {
+		t.Errorf("wrong token got %s", other.resp.Status)
+	}
+
+	// within the grace the broadcast goes on, and the listener with it
+	resumed := broadcast(t, ts, "/show.mp3", "archive=true&resume="+token)
+	if resumed.resp.StatusCode != http.StatusOK || resumed.resp.Header.Get("X-Owner-Token") != token ||
+		resumed.resp.Header.Get("X-Received") != "4" {
+		t.Fatalf("resume got %s, received %q", resumed.resp.Status, resumed.resp.Header.Get("X-Received"))
+	}
+	resumed.Write([]byte("two"))
+	hear(t, body, "two")
+	resumed.drop()
+
+	// after it a resume starts a new broadcast
+	time.Sleep(2 * s.ResumeGrace)
+	late := broadcast(t, ts, "/show.mp3", "archive=true&resume="+token)
+	if late.resp.StatusCode != http.StatusOK || late.resp.Header.Get("X-Owner-Token") == token ||
+		late.resp.Header.Get("X-Received") != "" {
+		t.Fatalf("late resume got %s, received %q", late.resp.Status, late.resp.Header.Get("X-Received"))
+	}
+	late.Write([]byte("three"))
+	late.Close()
+	waitFor(t, func() bool {
+		names := archives()
+		for _, name := range names {
+			if s.beingMade(name) {
+				return false
+			}
+		}
+		return len(names) == 2
+	})
+
+	want := map[string]bool{"one two": true, "three": true}
+	for _, name := range archives() {
+		if b, _ := storage.ReadAll(s.Storage, name); !want[string(b)] {
+			t.Errorf("%s has %q", name, b)
+		}
+	}
+}
+
+func TestSlowListener(t *testing.T) {
+	slow, fast := make(chan stream, 1), make(chan stream, 2)
+	s := &Server{channels: map[string]map[float64]chan stream{"/show.mp3": {1: slow, 2: fast}}}
+	s.broadcast("/show.mp3", []byte("a"))
+	// the slow listener is cut off instead of holding up the broadcaster
+	s.broadcast("/show.mp3", []byte("b"))
+	if <-slow; len(s.channels["/show.mp3"]) != 1 {
+		t.Errorf("%d listeners left", len(s.channels["/show.mp3"]))
+	}
+	if _, ok := <-slow; ok {
+		t.Error("slow listener still open")
+	}
+	s.endListeners("/show.mp3")
+	for _, want := range []string{"a", "b"} {
+		if got := <-fast; string(got.b) != want {
+			t.Errorf("fast listener got %q", got.b)
+		}
+	}
+	if _, ok := <-fast; ok {
+		t.Error("listener not told the stream ended")
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/schollz/streammyaudio/src/chat"
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+// transcriptExt is appended to an archive's filename to name the chat that
+// was recorded with it
+const transcriptExt = ".chat.json"
+
+// transcriptEntry is a chat message in the transcript of an archive
+type transcriptEntry struct {
+	// Offset is when the message was sent, in seconds from the start of the
+	// recording
+	Offset float64 `json:"offset"`
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Text   string  `json:"text"`
+	Host   bool    `json:"host,omitempty"`
+}
+
+func isTranscriptFile(fname string) bool {
+	return strings.HasSuffix(fname, transcriptExt)
+}
+
+// recordChat adds chat messages to the transcript of the stream the room
+// belongs to, if that stream is being archived. Deleted messages are taken
+// out again.
+func (s *Server) recordChat(room string, m chat.Message) {
+	if m.Type != chat.TypeMessage && m.Type != chat.TypeDelete {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for p, src := range s.sources {
+		if src.archive == nil || streamName(p) != room {
+			continue
+		}
+		if m.Type == chat.TypeDelete {
+			for i, entry := range src.transcript {
+				if entry.ID == m.Target {
+					src.transcript = append(src.transcript[:i], src.transcript[i+1:]...)
+					break
+				}
+			}
+			continue
+		}
+		src.transcript = append(src.transcript, transcriptEntry{
+			Offset: m.Time.Sub(src.started).Seconds(),
+			ID:     m.ID,
+			Name:   m.Name,
+			Text:   m.Text,
+			Host:   m.Host,
+		})
+	}
+}
+
+// saveTranscript writes the chat recorded during a broadcast next to its
+// archive
+func (s *Server) saveTranscript(filename string, src *source) {
+	s.mutex.Lock()
+	entries := src.transcript
+	s.mutex.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	b, err := json.Marshal(entries)
+	if err == nil {
+		err = storage.WriteAll(s.Storage, filename+transcriptExt, b)
+	}
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// clipTranscript keeps the chat sent between start and end of filename for
+// the clip at newname, shifted to the start of the clip
+func (s *Server) clipTranscript(filename, newname string, start, end time.Duration) (err error) {
+	b, err := storage.ReadAll(s.Storage, filename+transcriptExt)
+	if err == storage.ErrNotExist {
+		return nil
+	} else if err != nil {
+		return
+	}
+	var entries, clipped []transcriptEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.Offset >= start.Seconds() && entry.Offset < end.Seconds() {
+			entry.Offset -= start.Seconds()
+			clipped = append(clipped, entry)
+		}
+	}
+	if len(clipped) == 0 {
+		if filename == newname {
+			err = s.Storage.Delete(filename + transcriptExt)
+		}
+		return
+	}
+	b, err = json.Marshal(clipped)
+	if err != nil {
+		return
+	}
+	return storage.WriteAll(s.Storage, newname+transcriptExt, b)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/schollz/streammyaudio/src/chat"
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+func TestRecordChat(t *testing.T) {
+	started := time.Now()
+	recording := &source{archive: nopWriteCloser{}, started: started}
+	s := &Server{sources: map[string]*source{
+		"/show.mp3":  recording,
+		"/other.mp3": {archive: nopWriteCloser{}, started: started},
+		// not archived, so nothing to record with
+		"/live.mp3": {started: started},
+	}}
+	say := func(room, id, text string, after time.Duration) {
+		s.recordChat(room, chat.Message{Type: chat.TypeMessage, ID: id, Name: "ann", Text: text, Time: started.Add(after)})
+	}
+	say("show", "1", "hello", 1500*time.Millisecond)
+	say("show", "2", "spam", 2*time.Second)
+	say("other", "3", "elsewhere", time.Second)
+	say("live", "4", "unrecorded", time.Second)
+	s.recordChat("show", chat.Message{Type: chat.TypeSystem, Text: "slow mode is on", Time: started})
+	s.recordChat("show", chat.Message{Type: chat.TypeMessage, ID: "5", Name: "dj", Text: "welcome", Host: true, Time: started.Add(3 * time.Second)})
+	// deleting takes it out of the recording too
+	s.recordChat("show", chat.Message{Type: chat.TypeDelete, Target: "2"})
+
+	want := []transcriptEntry{
+		{Offset: 1.5, ID: "1", Name: "ann", Text: "hello"},
+		{Offset: 3, ID: "5", Name: "dj", Text: "welcome", Host: true},
+	}
+	if got, _ := json.Marshal(recording.transcript); string(got) != string(mustJSON(want)) {
+		t.Errorf("recorded %s", got)
+	}
+	if len(s.sources["/live.mp3"].transcript) != 0 {
+		t.Error("recorded a stream that is not archived")
+	}
+}
+
+func TestClipTranscript(t *testing.T) {
+	s := &Server{Storage: storage.NewLocal(t.TempDir())}
+	storage.WriteAll(s.Storage, "1/show.mp3"+transcriptExt, mustJSON([]transcriptEntry{
+		{Offset: 5, ID: "1", Text: "before"},
+		{Offset: 10, ID: "2", Text: "at the start"},
+		{Offset: 15.5, ID: "3", Text: "during", Host: true},
+		{Offset: 20, ID: "4", Text: "at the end"},
+	}))
+	read := func(name string) (entries []transcriptEntry) {
+		b, err := storage.ReadAll(s.Storage, name+transcriptExt)
+		if err != nil {
+			return nil
+		}
+		json.Unmarshal(b, &entries)
+		return
+	}
+
+	for _, tc := range []struct {
+		name       string
+		start, end time.Duration
+		want       []transcriptEntry
+	}{
+		{"middle", 10 * time.Second, 20 * time.Second, []transcriptEntry{
+			{Offset: 0, ID: "2", Text: "at the start"},
+			{Offset: 5.5, ID: "3", Text: "during", Host: true},
+		}},
+		{"from the start", 0, 6 * time.Second, []transcriptEntry{{Offset: 5, ID: "1", Text: "before"}}},
+		{"quiet part", 30 * time.Second, 40 * time.Second, nil},
+	} {
+		newname := "1/" + tc.name + ".mp3"
+		if err := s.clipTranscript("1/show.mp3", newname, tc.start, tc.end); err != nil {
+			t.Fatalf("%q: %v", tc.name, err)
+		}
+		if got := read(newname); string(mustJSON(got)) != string(mustJSON(tc.want)) {
+			t.Errorf("%q: clipped %+v", tc.name, got)
+		}
+	}
+
+	// an archive without chat has nothing to clip
+	if err := s.clipTranscript("1/none.mp3", "1/none-clip.mp3", 0, time.Minute); err != nil {
+		t.Error(err)
+	}
+	// replacing the archive with a quiet part leaves it without chat
+	if err := s.clipTranscript("1/show.mp3", "1/show.mp3", 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Storage.Stat("1/show.mp3" + transcriptExt); err != storage.ErrNotExist {
+		t.Errorf("kept the chat of the replaced archive: %v", err)
+	}
+}
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(b []byte) (int, error) { return len(b), nil }
+func (nopWriteCloser) Close() error                { return nil }
+
+func mustJSON(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
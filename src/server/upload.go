@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/h2non/filetype"
+	log "github.com/schollz/logger"
+)
+
+// DefaultMaxUpload is the largest upload accepted when Server.MaxUpload is unset
+const DefaultMaxUpload = 200 << 20
+
+// handleUpload stores a pre-recorded file in the archive folder, laid out the
+// same way as a live recording.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "upload with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	maxUpload := s.MaxUpload
+	if maxUpload <= 0 {
+		maxUpload = DefaultMaxUpload
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected multipart form", http.StatusBadRequest)
+		return
+	}
+	name := ""
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, "no file uploaded", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			uploadError(w, err)
+			return
+		}
+		switch part.FormName() {
+		case "name":
+			b, _ := io.ReadAll(io.LimitReader(part, 256))
+			name = strings.TrimSpace(string(b))
+		case "file":
+			if name == "" {
+				name = part.FileName()
+			}
+			token := newToken()
+			filename, err := s.saveUpload(name, part, token)
+			if err != nil {
+				uploadError(w, err)
+				return
+			}
+			log.Infof("uploaded %s", filename)
+			s.addRecent(filename)
+			s.notifyArchive(EventArchiveFinalized, filename, "")
+			w.Header().Set("X-Owner-Token", token)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "%s\n", path.Join("archived", filename))
+			return
+		}
+	}
+}
+
+// saveUpload checks that the upload is audio before writing it under a new
+// timestamped folder, owned by whoever holds token. A name already taken in
+// that folder gets a number.
+func (s *Server) saveUpload(name string, r io.Reader, token string) (filename string, err error) {
+	head := make([]byte, 3072)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF {
+		err = fmt.Errorf("file is empty")
+		return
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+	err = nil
+	head = head[:n]
+
+	mtype := mimetype.Detect(head)
+	if !strings.HasPrefix(mtype.String(), "audio/") && !filetype.IsAudio(head) {
+		err = errNotAudio{mtype.String()}
+		return
+	}
+	ext := mtype.Extension()
+	if kind, errMatch := filetype.Match(head); errMatch == nil && filetype.IsAudio(head) {
+		ext = "." + kind.Extension
+	}
+
+	// This join with "/" prevents directory traversal with an implicit clean
+	name = path.Base(path.Join("/", filepath.ToSlash(name)))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" || name == "/" || name == "." {
+		name = "upload"
+	}
+
+	filename = s.newArchiveName(name + ext)
+	defer s.madeArchive(filename)
+	f, err := s.Storage.Create(filename)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(head)
+	if err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = s.writeMeta(filename, archiveMeta{
+			Name:    path.Base(filename),
+			Created: time.Now(),
+			Owner:   hashToken(token),
+		})
+	}
+	if err != nil {
+		s.Storage.Delete(filename)
+	}
+	return
+}
+
+type errNotAudio struct {
+	mimetype string
+}
+
+func (e errNotAudio) Error() string {
+	return fmt.Sprintf("'%s' is not audio", e.mimetype)
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		http.Error(w, fmt.Sprintf("upload is larger than %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+	case errors.As(err, new(errNotAudio)):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		log.Error(err)
+		http.Error(w, "could not save upload", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/schollz/streammyaudio/src/storage"
+)
+
+func TestSaveUpload(t *testing.T) {
+	s := &Server{Storage: storage.NewLocal(t.TempDir())}
+
+	// the same name twice in the same minute keeps both
+	first, err := s.saveUpload("show.mp3", bytes.NewReader(testMP3(10)), "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.saveUpload("../show.mp3", bytes.NewReader(testMP3(20)), "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path.Base(first) != "show.mp3" || path.Base(second) != "show-2.mp3" || path.Dir(first) != path.Dir(second) {
+		t.Fatalf("saved as %s and %s", first, second)
+	}
+	for filename, token := range map[string]string{first: "first", second: "second"} {
+		meta, err := s.readMeta(filename)
+		if err != nil || meta.Name != path.Base(filename) || meta.Owner != hashToken(token) {
+			t.Errorf("%s: %+v, %v", filename, meta, err)
+		}
+	}
+	if info, _ := s.Storage.Stat(first); info.Size != 10*417 {
+		t.Errorf("first upload is %d bytes", info.Size)
+	}
+
+	if _, err = s.saveUpload("notes.txt", strings.NewReader("just some text"), "third"); err == nil {
+		t.Error("saved text as audio")
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Types of webhook events
+const (
+	EventStreamStart      = "stream.start"
+	EventStreamStop       = "stream.stop"
+	EventStreamAdvertise  = "stream.advertise"
+	EventStreamMetadata   = "stream.metadata"
+	EventStreamSilence    = "stream.silence"
+	EventArchiveFinalized = "archive.finalized"
+	EventArchiveRenamed   = "archive.renamed"
+	EventArchiveRemoved   = "archive.removed"
+	EventChatMessage      = "chat.message"
+)
+
+type streamEvent struct {
+	Stream    string `json:"stream"`
+	Advertise bool   `json:"advertise"`
+	Archive   bool   `json:"archive"`
+}
+
+type archiveEvent struct {
+	// Archive is where the archive is played, relative to the server
+	Archive string `json:"archive"`
+	Stream  string `json:"stream,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	// Renamed is where a renamed archive was before
+	Renamed string `json:"renamed,omitempty"`
+}
+
+type chatEvent struct {
+	Room string `json:"room"`
+	Name string `json:"name"`
+	Text string `json:"text"`
+	Host bool   `json:"host,omitempty"`
+	Via  string `json:"via,omitempty"`
+}
+
+// notify sends a webhook event, if there are webhooks
+func (s *Server) notify(typ string, data any) {
+	if s.Webhooks != nil {
+		s.Webhooks.Send(typ, data)
+	}
+}
+
+// notifyArchive sends an event about the archive at filename
+func (s *Server) notifyArchive(typ, filename string, renamed string) {
+	e := archiveEvent{
+		Archive: path.Join("archived", filename),
+		Stream:  streamName(path.Base(filename)),
+	}
+	if renamed != "" {
+		e.Renamed = path.Join("archived", renamed)
+	}
+	if info, err := s.Storage.Stat(filename); err == nil {
+		e.Size = info.Size
+	}
+	s.notify(typ, e)
+}
+
+// serveWebhooks shows the latest webhook deliveries to whoever has the
+// admin token, as "Authorization: Bearer <token>"
+func (s *Server) serveWebhooks(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+		http.Error(w, "admin token needed", http.StatusUnauthorized)
+		return
+	}
+	if s.Webhooks == nil {
+		http.Error(w, "no webhooks", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Webhooks.Deliveries())
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/schollz/streammyaudio/src/filecreated"
+)
+
+// Local stores objects as files under a folder
+type Local struct {
+	Folder string
+}
+
+// NewLocal returns storage rooted at folder
+func NewLocal(folder string) *Local {
+	return &Local{Folder: folder}
+}
+
+func (l *Local) path(name string) string {
+	return filepath.Join(l.Folder, filepath.FromSlash(Clean(name)))
+}
+
+func (l *Local) Create(name string) (w io.WriteCloser, err error) {
+	p := l.path(name)
+	os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	return os.Create(p)
+}
+
+func (l *Local) List() (infos []Info, err error) {
+	err = filepath.Walk(l.Folder,
+		func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			name, err := filepath.Rel(l.Folder, p)
+			if err != nil {
+				return err
+			}
+			infos = append(infos, Info{
+				Name:     filepath.ToSlash(name),
+				Size:     info.Size(),
+				Modified: filecreated.FileCreated(p),
+			})
+			return nil
+		})
+	return
+}
+
+func (l *Local) Stat(name string) (info Info, err error) {
+	p := l.path(name)
+	finfo, err := os.Stat(p)
+	if err != nil {
+		err = localError(err)
+		return
+	}
+	info = Info{
+		Name:     Clean(name),
+		Size:     finfo.Size(),
+		Modified: filecreated.FileCreated(p),
+	}
+	return
+}
+
+func (l *Local) Rename(oldname, newname string) (err error) {
+	p := l.path(newname)
+	os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	return localError(os.Rename(l.path(oldname), p))
+}
+
+func (l *Local) Delete(name string) (err error) {
+	return localError(os.Remove(l.path(name)))
+}
+
+func (l *Local) OpenRange(name string, offset, length int64) (r io.ReadCloser, err error) {
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		err = localError(err)
+		return
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	return err
+}
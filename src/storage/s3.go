@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores objects in a bucket of any S3-compatible service (AWS, MinIO,
+// ...). Buckets are addressed by path, e.g. http://localhost:9000/bucket/key,
+// which every implementation supports.
+type S3 struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to every object name
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *S3) key(name string) string {
+	return s.Prefix + Clean(name)
+}
+
+func (s *S3) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+// Create buffers the object in a temporary file, it is uploaded on Close.
+func (s *S3) Create(name string) (w io.WriteCloser, err error) {
+	return s.newWriter(name)
+}
+
+func (s *S3) List() (infos []Info, err error) {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if s.Prefix != "" {
+			query.Set("prefix", s.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var resp *http.Response
+		resp, err = s.do("GET", "", query, nil, nil, emptySHA256)
+		if err != nil {
+			return
+		}
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return
+		}
+		for _, c := range result.Contents {
+			infos = append(infos, Info{
+				Name:     strings.TrimPrefix(c.Key, s.Prefix),
+				Size:     c.Size,
+				Modified: c.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return
+}
+
+func (s *S3) Stat(name string) (info Info, err error) {
+	resp, err := s.do("HEAD", s.key(name), nil, nil, nil, emptySHA256)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	info = Info{
+		Name: Clean(name),
+		Size: resp.ContentLength,
+	}
+	info.Modified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return
+}
+
+func (s *S3) Rename(oldname, newname string) (err error) {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", "/"+s.Bucket+"/"+uriEncode(s.key(oldname), false))
+	resp, err := s.do("PUT", s.key(newname), nil, header, nil, emptySHA256)
+	if err != nil {
+		return
+	}
+	// a copy can fail after the 200 has been sent
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if bytes.Contains(body, []byte("<Error>")) {
+		return fmt.Errorf("s3 copy %s: %s", oldname, body)
+	}
+	return s.Delete(oldname)
+}
+
+func (s *S3) Delete(name string) (err error) {
+	if _, err = s.Stat(name); err != nil {
+		// S3 deletes succeed for missing objects, but callers expect to hear
+		return
+	}
+	resp, err := s.do("DELETE", s.key(name), nil, nil, nil, emptySHA256)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+func (s *S3) OpenRange(name string, offset, length int64) (r io.ReadCloser, err error) {
+	header := http.Header{}
+	if length >= 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do("GET", s.key(name), nil, header, nil, emptySHA256)
+	if err != nil {
+		return
+	}
+	return resp.Body, nil
+}
+
+// do sends a signed request, non-2xx responses are returned as errors
+func (s *S3) do(method, key string, query url.Values, header http.Header, body io.Reader, payloadHash string) (resp *http.Response, err error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + uriEncode(key, false))
+	if err != nil {
+		return
+	}
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if f, ok := body.(*os.File); ok {
+		finfo, errStat := f.Stat()
+		if errStat != nil {
+			return nil, errStat
+		}
+		req.ContentLength = finfo.Size()
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err = s.client().Do(req)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotExist
+	} else if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, key, resp.Status, b)
+	}
+	return
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := []string{"host"}
+	canonical := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") {
+			signed = append(signed, k)
+			canonical[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(signed)
+	var headers strings.Builder
+	for _, k := range signed {
+		headers.WriteString(k + ":" + canonical[k] + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// uriEncode escapes everything but unreserved characters, as SigV4 requires
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Writer spools an object to disk until it can be uploaded in one PUT
+type s3Writer struct {
+	s    *S3
+	name string
+	f    *os.File
+	h    hash.Hash
+}
+
+func (s *S3) newWriter(name string) (w *s3Writer, err error) {
+	f, err := os.CreateTemp("", "s3upload")
+	if err != nil {
+		return
+	}
+	w = &s3Writer{s: s, name: name, f: f, h: sha256.New()}
+	return
+}
+
+func (w *s3Writer) Write(p []byte) (n int, err error) {
+	n, err = w.f.Write(p)
+	w.h.Write(p[:n])
+	return
+}
+
+func (w *s3Writer) Close() (err error) {
+	defer w.abort()
+	if _, err = w.f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := w.s.do("PUT", w.s.key(w.name), nil, header, w.f, hex.EncodeToString(w.h.Sum(nil)))
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+func (w *s3Writer) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is enough of an S3-compatible service, like MinIO, to test S3
+// against. Every request must be signed with the access and secret key.
+type fakeS3 struct {
+	t         *testing.T
+	bucket    string
+	accessKey string
+	secretKey string
+	region    string
+	// pageSize is how many keys a listing returns at a time
+	pageSize int
+
+	mutex sync.Mutex
+	// rejecting is set when requests are expected to be badly signed
+	rejecting bool
+	objects   map[string][]byte
+	modified  map[string]time.Time
+	requests  []string
+}
+
+func newFakeS3(t *testing.T) (f *fakeS3, s *S3) {
+	f = &fakeS3{
+		t:         t,
+		bucket:    "archives",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:    "us-east-1",
+		pageSize:  2,
+		objects:   make(map[string][]byte),
+		modified:  make(map[string]time.Time),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	s = &S3{
+		Endpoint:  srv.URL,
+		Region:    f.region,
+		Bucket:    f.bucket,
+		AccessKey: f.accessKey,
+		SecretKey: f.secretKey,
+	}
+	return
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.verify(r, body); err != nil {
+		if !f.rejecting {
+			f.t.Errorf("%s %s: %s", r.Method, r.URL, err)
+		}
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket)
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	f.requests = append(f.requests, r.Method+" "+key)
+	switch {
+	case r.Method == "GET" && key == "":
+		f.list(w, r)
+	case r.Method == "PUT" && r.Header.Get("x-amz-copy-source") != "":
+		source := strings.TrimPrefix(r.Header.Get("x-amz-copy-source"), "/"+f.bucket+"/")
+		b, ok := f.objects[source]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		f.put(key, b)
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == "PUT":
+		f.put(key, body)
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" || r.Method == "HEAD":
+		b, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", f.modified[key].Format(http.TimeFormat))
+		http.ServeContent(w, r, "", f.modified[key], bytes.NewReader(b))
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) put(key string, b []byte) {
+	f.objects[key] = append([]byte(nil), b...)
+	f.modified[key] = time.Now().UTC().Truncate(time.Second)
+}
+
+// sent lists the requests so far, as "METHOD key"
+func (f *fakeS3) sent() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// list answers ListObjectsV2 a page at a time
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2", http.StatusBadRequest)
+		return
+	}
+	keys := []string{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	type content struct {
+		Key          string
+		Size         int
+		LastModified time.Time
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}
+	for i := start; i < len(keys) && i < start+f.pageSize; i++ {
+		result.Contents = append(result.Contents, content{keys[i], len(f.objects[keys[i]]), f.modified[keys[i]]})
+	}
+	if start+f.pageSize < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(start + f.pageSize)
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify checks the AWS Signature Version 4 of r the way S3 does
+func (f *fakeS3) verify(r *http.Request, body []byte) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("not signed with SigV4: %q", r.Header.Get("Authorization"))
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ", ") {
+		k, v, _ := strings.Cut(field, "=")
+		fields[k] = v
+	}
+	amzDate := r.Header.Get("x-amz-date")
+	when, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(when).Abs() > 15*time.Minute {
+		return fmt.Errorf("x-amz-date %q", amzDate)
+	}
+	scope := amzDate[:8] + "/" + f.region + "/s3/aws4_request"
+	if fields["Credential"] != f.accessKey+"/"+scope {
+		return fmt.Errorf("credential %q", fields["Credential"])
+	}
+	payloadHash := r.Header.Get("x-amz-content-sha256")
+	if sum := sha256.Sum256(body); payloadHash != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("payload hash %q of %d bytes", payloadHash, len(body))
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) || signed[0] != "host" {
+		return fmt.Errorf("signed headers %q", fields["SignedHeaders"])
+	}
+	for _, required := range []string{"x-amz-content-sha256", "x-amz-date"} {
+		if !strings.Contains(fields["SignedHeaders"], required) {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var headers strings.Builder
+	for _, k := range signed {
+		v := r.Header.Get(k)
+		if k == "host" {
+			v = r.Host
+		}
+		headers.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	canonical := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery,
+		headers.String(), fields["SignedHeaders"], payloadHash}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{amzDate[:8], f.region, "s3", "aws4_request", stringToSign} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(part))
+		key = h.Sum(nil)
+	}
+	if fields["Signature"] != hex.EncodeToString(key) {
+		return fmt.Errorf("signature does not match for\n%s", canonical)
+	}
+	return nil
+}
+
+func TestS3Signs(t *testing.T) {
+	f, s := newFakeS3(t)
+	// keys that need escaping are signed as they are sent
+	if err := WriteAll(s, "2024/my show (live)+more.mp3", []byte("audio")); err != nil {
+		t.Fatal(err)
+	}
+	f.mutex.Lock()
+	if _, ok := f.objects["2024/my show (live)+more.mp3"]; !ok {
+		t.Errorf("stored %v", f.objects)
+	}
+	// a wrong secret is turned away
+	f.rejecting = true
+	f.mutex.Unlock()
+	s.SecretKey = "wrong"
+	if _, err := s.Stat("2024/my show (live)+more.mp3"); err == nil || err == ErrNotExist {
+		t.Errorf("wrong secret: %v", err)
+	}
+}
+
+func TestS3List(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.Prefix = "radio/"
+	for i := 0; i < 5; i++ {
+		WriteAll(s, fmt.Sprintf("1/%d.mp3", i), []byte("audio"))
+	}
+	// not ours
+	f.mutex.Lock()
+	f.put("other/x.mp3", nil)
+	f.mutex.Unlock()
+
+	infos, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name)
+		if info.Size != 5 || info.Modified.IsZero() {
+			t.Errorf("%+v", info)
+		}
+	}
+	if strings.Join(names, " ") != "1/0.mp3 1/1.mp3 1/2.mp3 1/3.mp3 1/4.mp3" {
+		t.Errorf("listed %v", names)
+	}
+	lists := 0
+	for _, request := range f.sent() {
+		if request == "GET " {
+			lists++
+		}
+	}
+	if lists != 3 {
+		t.Errorf("listed in %d pages", lists)
+	}
+}
+
+func TestS3Create(t *testing.T) {
+	f, s := newFakeS3(t)
+	WriteAll(s, "show.mp3", []byte("first"))
+
+	w, err := s.Create("show.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("second"))
+	// nothing is uploaded before Close
+	if b, _ := ReadAll(s, "show.mp3"); string(b) != "first" {
+		t.Errorf("before Close %q", b)
+	}
+	f.mutex.Lock()
+	f.requests = nil
+	f.mutex.Unlock()
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ReadAll(s, "show.mp3"); string(b) != "second" {
+		t.Errorf("after Close %q", b)
+	}
+	if requests := f.sent(); len(requests) < 1 || requests[0] != "PUT show.mp3" {
+		t.Errorf("requests %v", requests)
+	}
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when an object is not in storage
+var ErrNotExist = errors.New("object does not exist")
+
+// Storage keeps archived recordings. Names are slash separated and relative
+// to the root of the storage, e.g. "202201021504/name.mp3".
+type Storage interface {
+	// Create starts a new object, replacing any object with the same name.
+	Create(name string) (io.WriteCloser, error)
+	// List returns every object in storage.
+	List() ([]Info, error)
+	// Stat describes a single object.
+	Stat(name string) (Info, error)
+	// Rename moves an object to a new name.
+	Rename(oldname, newname string) error
+	// Delete removes an object.
+	Delete(name string) error
+	// OpenRange reads length bytes from offset. A negative length reads to
+	// the end of the object.
+	OpenRange(name string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Info describes a stored object
+type Info struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// Clean turns name into a storage name that cannot leave the storage root
+func Clean(name string) string {
+	// This join with "/" prevents directory traversal with an implicit clean
+	return strings.TrimPrefix(path.Join("/", strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+// ReadAll reads a whole object
+func ReadAll(s Storage, name string) (b []byte, err error) {
+	r, err := s.OpenRange(name, 0, -1)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// WriteAll replaces an object with b
+func WriteAll(s Storage, name string, b []byte) (err error) {
+	w, err := s.Create(name)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(b)
+	if errClose := w.Close(); err == nil {
+		err = errClose
+	}
+	return
+}
+
+// NewReadSeeker lets an object be served with http.ServeContent, fetching
+// only the ranges that are read.
+func NewReadSeeker(s Storage, name string) (rs io.ReadSeekCloser, info Info, err error) {
+	info, err = s.Stat(name)
+	if err != nil {
+		return
+	}
+	rs = &rangeReader{s: s, name: name, size: info.Size}
+	return
+}
+
+type rangeReader struct {
+	s      Storage
+	name   string
+	size   int64
+	offset int64
+	r      io.ReadCloser
+}
+
+func (rr *rangeReader) Read(p []byte) (n int, err error) {
+	if rr.offset >= rr.size {
+		return 0, io.EOF
+	}
+	if rr.r == nil {
+		rr.r, err = rr.s.OpenRange(rr.name, rr.offset, -1)
+		if err != nil {
+			return
+		}
+	}
+	n, err = rr.r.Read(p)
+	rr.offset += int64(n)
+	return
+}
+
+func (rr *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += rr.offset
+	case io.SeekEnd:
+		offset += rr.size
+	}
+	if offset < 0 {
+		return rr.offset, errors.New("seek before start of object")
+	}
+	if offset != rr.offset && rr.r != nil {
+		rr.r.Close()
+		rr.r = nil
+	}
+	rr.offset = offset
+	return offset, nil
+}
+
+func (rr *rangeReader) Close() (err error) {
+	if rr.r != nil {
+		err = rr.r.Close()
+		rr.r = nil
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		open func(t *testing.T) Storage
+	}{
+		{"local", func(t *testing.T) Storage { return NewLocal(t.TempDir()) }},
+		{"s3", func(t *testing.T) Storage {
+			_, s := newFakeS3(t)
+			return s
+		}},
+		{"s3 with prefix", func(t *testing.T) Storage {
+			_, s := newFakeS3(t)
+			s.Prefix = "radio/"
+			return s
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testStorage(t, tc.open(t))
+		})
+	}
+}
+
+// testStorage puts s through everything the server does with archives
+func testStorage(t *testing.T, s Storage) {
+	read := func(name string, offset, length int64) string {
+		t.Helper()
+		r, err := s.OpenRange(name, offset, length)
+		if err != nil {
+			t.Fatalf("open %s: %s", name, err)
+		}
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		return string(b)
+	}
+
+	if err := WriteAll(s, "1/show.mp3", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat("1/show.mp3"); err != nil || info.Name != "1/show.mp3" || info.Size != 10 {
+		t.Errorf("stat %+v, %v", info, err)
+	}
+	for _, r := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "0123456789"},
+		{3, -1, "3456789"},
+		{3, 4, "3456"},
+		{0, 1, "0"},
+	} {
+		if got := read("1/show.mp3", r.offset, r.length); got != r.want {
+			t.Errorf("range %d+%d: %q", r.offset, r.length, got)
+		}
+	}
+
+	rs, info, err := NewReadSeeker(s, "1/show.mp3")
+	if err != nil || info.Size != 10 {
+		t.Fatalf("read seeker %+v, %v", info, err)
+	}
+	rs.Seek(-3, io.SeekEnd)
+	if b, _ := io.ReadAll(rs); string(b) != "789" {
+		t.Errorf("seeked to %q", b)
+	}
+	rs.Close()
+
+	WriteAll(s, "1/show.mp3.chat.json", []byte("[]"))
+
+	// Create replaces
+	WriteAll(s, "2/other.mp3", []byte("old"))
+	WriteAll(s, "2/other.mp3", []byte("new"))
+	if got := read("2/other.mp3", 0, -1); got != "new" {
+		t.Errorf("replaced with %q", got)
+	}
+	WriteAll(s, "2/copy.mp3", []byte("copy"))
+
+	if err = s.Rename("1/show.mp3", "3/renamed.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("3/renamed.mp3", 0, -1); got != "0123456789" {
+		t.Errorf("renamed %q", got)
+	}
+	if err = s.Delete("2/copy.mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "1/show.mp3.chat.json 2/other.mp3 3/renamed.mp3" {
+		t.Errorf("listed %s", got)
+	}
+
+	// names cannot leave the storage
+	if err = WriteAll(s, "../../escaped.mp3", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Stat("escaped.mp3"); err != nil {
+		t.Errorf("escaped: %v", err)
+	}
+
+	// everything tells about missing objects the same way
+	missing := map[string]error{}
+	_, missing["stat"] = s.Stat("1/show.mp3")
+	_, missing["open"] = s.OpenRange("1/show.mp3", 0, -1)
+	missing["rename"] = s.Rename("1/show.mp3", "4/x.mp3")
+	missing["delete"] = s.Delete("1/show.mp3")
+	for op, err := range missing {
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("%s of a missing object: %v", op, err)
+		}
+	}
+}
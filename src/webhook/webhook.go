@@ -0,0 +1,225 @@
+// Package webhook delivers signed JSON events to configured URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+// Defaults of Hooks
+const (
+	DefaultAttempts = 5
+	DefaultBackoff  = 2 * time.Second
+	// deliveries kept in the log
+	DefaultLogSize = 100
+)
+
+// events waiting for each URL, more are dropped
+const queueSize = 256
+
+// Event is the JSON body of a webhook
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Delivery is an attempt to deliver an event to a URL, kept in the log
+type Delivery struct {
+	Event    string    `json:"event"`
+	Type     string    `json:"type"`
+	URL      string    `json:"url"`
+	Time     time.Time `json:"time"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration float64   `json:"duration"`
+}
+
+// Hooks posts events to URLs, one URL at a time so each gets its events in
+// order. Every body is signed with Secret in the X-Signature-256 header as
+// "sha256=<hex HMAC-SHA256 of the body>". Failed deliveries are retried
+// with exponential backoff.
+type Hooks struct {
+	URLs []string
+	// Secret signs the bodies, they are not signed if it is empty
+	Secret string
+	// Attempts is how often a delivery is tried, DefaultAttempts if zero
+	Attempts int
+	// Backoff is the wait before the first retry, DefaultBackoff if zero.
+	// It doubles after every failed attempt.
+	Backoff time.Duration
+	// Client posts the events, http.DefaultClient with a timeout if nil
+	Client *http.Client
+
+	once   sync.Once
+	queues map[string]chan Event
+
+	mutex      sync.Mutex
+	deliveries []Delivery
+}
+
+func (h *Hooks) init() {
+	h.once.Do(func() {
+		if h.Attempts == 0 {
+			h.Attempts = DefaultAttempts
+		}
+		if h.Backoff == 0 {
+			h.Backoff = DefaultBackoff
+		}
+		if h.Client == nil {
+			h.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+		h.queues = make(map[string]chan Event)
+		for _, u := range h.URLs {
+			h.queues[u] = make(chan Event, queueSize)
+		}
+	})
+}
+
+// Run delivers events until ctx is done
+func (h *Hooks) Run(ctx context.Context) {
+	h.init()
+	var wg sync.WaitGroup
+	for u, queue := range h.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-queue:
+					h.deliver(ctx, u, e)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Send queues an event for every URL. It never blocks, events are dropped
+// for URLs that are too far behind.
+func (h *Hooks) Send(typ string, data any) {
+	h.init()
+	e := Event{ID: newID(), Type: typ, Time: time.Now().UTC(), Data: data}
+	for u, queue := range h.queues {
+		select {
+		case queue <- e:
+		default:
+			log.Infof("webhook %s is behind, dropped %s", u, typ)
+		}
+	}
+}
+
+// Deliveries returns the latest deliveries, oldest first
+func (h *Hooks) Deliveries() []Delivery {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Delivery(nil), h.deliveries...)
+}
+
+func (h *Hooks) record(d Delivery) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.deliveries = append(h.deliveries, d)
+	if len(h.deliveries) > DefaultLogSize {
+		h.deliveries = h.deliveries[len(h.deliveries)-DefaultLogSize:]
+	}
+}
+
+// Sign returns the signature of body for the X-Signature-256 header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts an event to a URL until it is accepted or out of attempts
+func (h *Hooks) deliver(ctx context.Context, u string, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	backoff := h.Backoff
+	for attempt := 1; attempt <= h.Attempts; attempt++ {
+		start := time.Now()
+		status, retry, err := h.post(ctx, u, e, body)
+		d := Delivery{
+			Event:    e.ID,
+			Type:     e.Type,
+			URL:      u,
+			Time:     start,
+			Attempt:  attempt,
+			Status:   status,
+			Duration: time.Since(start).Seconds(),
+		}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		h.record(d)
+		if err == nil {
+			log.Debugf("delivered %s to %s", e.Type, u)
+			return
+		}
+		log.Infof("webhook %s, %s attempt %d: %s", u, e.Type, attempt, err)
+		if !retry || attempt == h.Attempts {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt, it reports whether a failure is worth retrying
+func (h *Hooks) post(ctx context.Context, u string, e Event, body []byte) (status int, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "streammyaudio-webhook")
+	req.Header.Set("X-Webhook-Event", e.Type)
+	req.Header.Set("X-Webhook-Delivery", e.ID)
+	if h.Secret != "" {
+		req.Header.Set("X-Signature-256", Sign(h.Secret, body))
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		retry = true
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	status = resp.StatusCode
+	if status/100 != 2 {
+		err = fmt.Errorf("%s", resp.Status)
+		// the endpoint is struggling, anything else will not change
+		retry = status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+	}
+	return
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
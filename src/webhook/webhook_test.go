@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliversSignedEvents(t *testing.T) {
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Signature-256"); got != Sign("secret", body) {
+			t.Errorf("signature %q", got)
+		}
+		if r.Header.Get("X-Webhook-Event") != "stream.start" {
+			t.Errorf("event header %q", r.Header.Get("X-Webhook-Event"))
+		}
+		var e Event
+		json.Unmarshal(body, &e)
+		received <- e
+	}))
+	defer srv.Close()
+
+	h := &Hooks{URLs: []string{srv.URL}, Secret: "secret"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Run(ctx)
+	h.Send("stream.start", map[string]string{"stream": "show"})
+
+	select {
+	case e := <-received:
+		if e.Type != "stream.start" || e.ID == "" || e.Data.(map[string]any)["stream"] != "show" {
+			t.Errorf("received %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing delivered")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Webhook-Event") {
+		case "flaky":
+			if calls.Add(1) < 3 {
+				http.Error(w, "try later", http.StatusServiceUnavailable)
+			}
+		case "refused":
+			http.Error(w, "no", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	h := &Hooks{URLs: []string{srv.URL}, Backoff: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Run(ctx)
+	h.Send("flaky", nil)
+	h.Send("refused", nil)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(h.Deliveries()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	deliveries := h.Deliveries()
+	if len(deliveries) != 4 {
+		t.Fatalf("deliveries %+v", deliveries)
+	}
+	for i, want := range []int{503, 503, 200, 400} {
+		if deliveries[i].Status != want {
+			t.Errorf("delivery %d: %+v", i, deliveries[i])
+		}
+	}
+	if deliveries[2].Attempt != 3 || deliveries[2].Error != "" || deliveries[3].Attempt != 1 {
+		t.Errorf("deliveries %+v", deliveries)
+	}
+}